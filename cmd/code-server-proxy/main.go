@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
@@ -18,13 +23,16 @@ const (
 	readBufferSize  = 4096
 	writeBufferSize = 4096
 	defaultPort     = 5555
+	shutdownTimeout = 10 * time.Second
 )
 
 func main() {
 	var (
 		logFormat  string
 		bind       string
+		grpcBind   string
 		configFile string
+		secret     string
 	)
 
 	app := cli.NewApp()
@@ -44,6 +52,18 @@ func main() {
 			EnvVar:      "BIND",
 			Value:       fmt.Sprintf(":%d", defaultPort),
 		},
+		cli.StringFlag{
+			Name:        "grpc-bind",
+			Destination: &grpcBind,
+			Usage:       "--grpc-bind=:5556 serves the gRPC management and health services, disabled if empty",
+			EnvVar:      "GRPC_BIND",
+		},
+		cli.StringFlag{
+			Name:        "affinity-secret",
+			Destination: &secret,
+			Usage:       "--affinity-secret=... signs affinity cookies, overrides routing.affinitySecret of the config",
+			EnvVar:      "AFFINITY_SECRET",
+		},
 		cli.StringFlag{
 			Name:        "c, config",
 			Destination: &configFile,
@@ -52,6 +72,8 @@ func main() {
 		},
 	}
 
+	app.Commands = []cli.Command{replayCommand}
+
 	app.Action = func(c *cli.Context) error {
 		// Config websocket upgrader
 		upgrader := websocket.Upgrader{
@@ -77,19 +99,51 @@ func main() {
 			proxy.UseCode(code),
 			proxy.UseLogger(logger),
 			proxy.UseConfig(configFile),
+			proxy.UseAffinitySecret(secret),
 		)
 		if err != nil {
 			logrus.Fatalf("Failed to create proxy: %v", err)
 		}
 
+		// Serve gRPC request
+		var g *proxy.GRPCServer
+		if grpcBind != "" {
+			l, lerr := net.Listen("tcp", grpcBind)
+			if lerr != nil {
+				logrus.Fatalf("Failed to listen: %v", lerr)
+			}
+
+			g = proxy.NewGRPCServer(p, proxy.DefaultProbeInterval)
+			go func() {
+				logger.Infof("code-server-proxy - gRPC running on '%s'", grpcBind)
+				if serr := g.Serve(l); serr != nil {
+					logrus.Fatalf("Failed to serve gRPC: %v", serr)
+				}
+			}()
+		}
+
 		// Serve HTTP request
 		logger.Infof("code-server-proxy - running on '%s', pid: %d",
 			bind,
 			os.Getpid(),
 		)
 
-		if err = http.ListenAndServe(bind, p); err != nil {
-			logrus.Fatalf("Failed to serve: %v", err)
+		server := &http.Server{Addr: bind, Handler: p}
+		go func() {
+			if serr := server.ListenAndServe(); serr != nil && serr != http.ErrServerClosed {
+				logrus.Fatalf("Failed to serve: %v", serr)
+			}
+		}()
+
+		// Shut down on SIGINT and SIGTERM
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-signals
+		logger.Infof("code-server-proxy - received %s, shutting down", sig)
+
+		shutdown(logger, server, g)
+		if cerr := p.Close(); cerr != nil {
+			logger.Errorf("Failed to close proxy: %v", cerr)
 		}
 
 		return nil
@@ -101,6 +155,33 @@ func main() {
 	}
 }
 
+// shutdown stops the HTTP and gRPC servers, waiting up to shutdownTimeout
+// for pending requests. Hijacked websocket connections are not waited for.
+func shutdown(logger *logrus.Logger, server *http.Server, g *proxy.GRPCServer) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if g != nil {
+		stopped := make(chan struct{})
+		go func() {
+			g.GracefulStop()
+			close(stopped)
+		}()
+		defer func() {
+			select {
+			case <-stopped:
+			case <-ctx.Done():
+				logger.Warn("Timed out waiting for gRPC requests, stopping")
+				g.Stop()
+			}
+		}()
+	}
+
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Warnf("Failed to shut down gracefully: %v", err)
+	}
+}
+
 func configureLogger(format string) (*logrus.Logger, error) {
 	logger := logrus.New()
 	logger.Level = logrus.InfoLevel
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/urfave/cli.v1"
+
+	"github.com/code-server-proxy/proxy"
+)
+
+var replayCommand = cli.Command{
+	Name:      "replay",
+	Usage:     "Replay a websocket session of a capture file",
+	UsageText: "code-server-proxy replay --capture FILE [--session ID] (--url ws://HOST/PROJECT/ | --listen :9000)",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "capture",
+			Usage: "--capture=capture.jsonl is the capture file of websocket frames",
+		},
+		cli.StringFlag{
+			Name:  "session",
+			Usage: "--session=ID replays the session ID, required if the capture holds several sessions",
+		},
+		cli.StringFlag{
+			Name:  "url",
+			Usage: "--url=ws://localhost:5555/project1/ plays the browser against the proxy or a code-server",
+		},
+		cli.StringFlag{
+			Name:  "listen",
+			Usage: "--listen=:9000 plays the code-server for the first connection of the proxy or a browser",
+		},
+	},
+	Action: replayCmdHandler,
+}
+
+// replayCmdHandler replays a captured session as the browser or the
+// code-server, and fails if the other side doesn't answer as captured
+func replayCmdHandler(c *cli.Context) error {
+	frames, err := readCapture(c.String("capture"), c.String("session"))
+	if err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	switch {
+	case c.String("url") != "" && c.String("listen") == "":
+		err = replayFrontend(c.String("url"), frames)
+	case c.String("listen") != "" && c.String("url") == "":
+		err = replayBackend(c.String("listen"), frames)
+	default:
+		return cli.NewExitError("Either --url or --listen is required", 1)
+	}
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Replay failed: %v", err), 1)
+	}
+
+	logrus.Infof("Replayed %d frames of session %s", len(frames), frames[0].Session)
+	return nil
+}
+
+// readCapture reads the frames of session from the capture file
+func readCapture(file, session string) ([]proxy.Frame, error) {
+	if file == "" {
+		return nil, fmt.Errorf("--capture is required")
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	frames, err := proxy.ReadCapture(f, session)
+	if err != nil {
+		return nil, err
+	}
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("No frames of session %q in %s", session, file)
+	}
+	for _, frame := range frames {
+		if frame.Session != frames[0].Session {
+			return nil, fmt.Errorf("%s holds several sessions, pick one by --session", file)
+		}
+	}
+	return frames, nil
+}
+
+// replayFrontend plays the browser on a connection to url
+func replayFrontend(url string, frames []proxy.Frame) error {
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return proxy.ReplayFrontend(conn, frames)
+}
+
+// replayBackend plays the code-server for the first connection accepted on
+// bind
+func replayBackend(bind string, frames []proxy.Frame) error {
+	l, err := net.Listen("tcp", bind)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	// Other requests, e.g. health probes of the proxy, are not replayed
+	errs := make(chan error, 1)
+	replay := proxy.ReplayHandler(frames, errs)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if !websocket.IsWebSocketUpgrade(r) {
+			http.NotFound(w, r)
+			return
+		}
+		replay(w, r)
+	}
+	go func() {
+		errs <- http.Serve(l, http.HandlerFunc(handler))
+	}()
+
+	logrus.Infof("Waiting for a websocket connection on '%s'", l.Addr())
+	return <-errs
+}
@@ -4,8 +4,10 @@
 package healthproto
 
 import (
+	context "context"
 	fmt "fmt"
 	proto "github.com/golang/protobuf/proto"
+	grpc "google.golang.org/grpc"
 	math "math"
 )
 
@@ -138,26 +140,756 @@ func (m *HealthCheck) GetCodeServers() []*CodeServerStatus {
 	return nil
 }
 
+type Server struct {
+	Path                 string         `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	Alias                string         `protobuf:"bytes,2,opt,name=alias,proto3" json:"alias,omitempty"`
+	Port                 int64          `protobuf:"varint,3,opt,name=port,proto3" json:"port,omitempty"`
+	Rewrite              []*RewriteRule `protobuf:"bytes,4,rep,name=rewrite,proto3" json:"rewrite,omitempty"`
+	Scheme               string         `protobuf:"bytes,5,opt,name=scheme,proto3" json:"scheme,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}       `json:"-"`
+	XXX_unrecognized     []byte         `json:"-"`
+	XXX_sizecache        int32          `json:"-"`
+}
+
+func (m *Server) Reset()         { *m = Server{} }
+func (m *Server) String() string { return proto.CompactTextString(m) }
+func (*Server) ProtoMessage()    {}
+func (*Server) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b205b526963b93a7, []int{2}
+}
+
+func (m *Server) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Server.Unmarshal(m, b)
+}
+func (m *Server) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Server.Marshal(b, m, deterministic)
+}
+func (m *Server) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Server.Merge(m, src)
+}
+func (m *Server) XXX_Size() int {
+	return xxx_messageInfo_Server.Size(m)
+}
+func (m *Server) XXX_DiscardUnknown() {
+	xxx_messageInfo_Server.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Server proto.InternalMessageInfo
+
+func (m *Server) GetPath() string {
+	if m != nil {
+		return m.Path
+	}
+	return ""
+}
+
+func (m *Server) GetAlias() string {
+	if m != nil {
+		return m.Alias
+	}
+	return ""
+}
+
+func (m *Server) GetPort() int64 {
+	if m != nil {
+		return m.Port
+	}
+	return 0
+}
+
+func (m *Server) GetRewrite() []*RewriteRule {
+	if m != nil {
+		return m.Rewrite
+	}
+	return nil
+}
+
+func (m *Server) GetScheme() string {
+	if m != nil {
+		return m.Scheme
+	}
+	return ""
+}
+
+type RewriteRule struct {
+	Name                 string   `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	StripPrefix          string   `protobuf:"bytes,2,opt,name=stripPrefix,proto3" json:"stripPrefix,omitempty"`
+	Match                string   `protobuf:"bytes,3,opt,name=match,proto3" json:"match,omitempty"`
+	Replace              string   `protobuf:"bytes,4,opt,name=replace,proto3" json:"replace,omitempty"`
+	AddPrefix            string   `protobuf:"bytes,5,opt,name=addPrefix,proto3" json:"addPrefix,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *RewriteRule) Reset()         { *m = RewriteRule{} }
+func (m *RewriteRule) String() string { return proto.CompactTextString(m) }
+func (*RewriteRule) ProtoMessage()    {}
+func (*RewriteRule) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b205b526963b93a7, []int{3}
+}
+
+func (m *RewriteRule) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RewriteRule.Unmarshal(m, b)
+}
+func (m *RewriteRule) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RewriteRule.Marshal(b, m, deterministic)
+}
+func (m *RewriteRule) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RewriteRule.Merge(m, src)
+}
+func (m *RewriteRule) XXX_Size() int {
+	return xxx_messageInfo_RewriteRule.Size(m)
+}
+func (m *RewriteRule) XXX_DiscardUnknown() {
+	xxx_messageInfo_RewriteRule.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RewriteRule proto.InternalMessageInfo
+
+func (m *RewriteRule) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *RewriteRule) GetStripPrefix() string {
+	if m != nil {
+		return m.StripPrefix
+	}
+	return ""
+}
+
+func (m *RewriteRule) GetMatch() string {
+	if m != nil {
+		return m.Match
+	}
+	return ""
+}
+
+func (m *RewriteRule) GetReplace() string {
+	if m != nil {
+		return m.Replace
+	}
+	return ""
+}
+
+func (m *RewriteRule) GetAddPrefix() string {
+	if m != nil {
+		return m.AddPrefix
+	}
+	return ""
+}
+
+type ServerList struct {
+	Servers              []*Server `protobuf:"bytes,1,rep,name=servers,proto3" json:"servers,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}  `json:"-"`
+	XXX_unrecognized     []byte    `json:"-"`
+	XXX_sizecache        int32     `json:"-"`
+}
+
+func (m *ServerList) Reset()         { *m = ServerList{} }
+func (m *ServerList) String() string { return proto.CompactTextString(m) }
+func (*ServerList) ProtoMessage()    {}
+func (*ServerList) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b205b526963b93a7, []int{4}
+}
+
+func (m *ServerList) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ServerList.Unmarshal(m, b)
+}
+func (m *ServerList) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ServerList.Marshal(b, m, deterministic)
+}
+func (m *ServerList) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ServerList.Merge(m, src)
+}
+func (m *ServerList) XXX_Size() int {
+	return xxx_messageInfo_ServerList.Size(m)
+}
+func (m *ServerList) XXX_DiscardUnknown() {
+	xxx_messageInfo_ServerList.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ServerList proto.InternalMessageInfo
+
+func (m *ServerList) GetServers() []*Server {
+	if m != nil {
+		return m.Servers
+	}
+	return nil
+}
+
+type FieldError struct {
+	Field                string   `protobuf:"bytes,1,opt,name=field,proto3" json:"field,omitempty"`
+	Message              string   `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *FieldError) Reset()         { *m = FieldError{} }
+func (m *FieldError) String() string { return proto.CompactTextString(m) }
+func (*FieldError) ProtoMessage()    {}
+func (*FieldError) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b205b526963b93a7, []int{5}
+}
+
+func (m *FieldError) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_FieldError.Unmarshal(m, b)
+}
+func (m *FieldError) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_FieldError.Marshal(b, m, deterministic)
+}
+func (m *FieldError) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_FieldError.Merge(m, src)
+}
+func (m *FieldError) XXX_Size() int {
+	return xxx_messageInfo_FieldError.Size(m)
+}
+func (m *FieldError) XXX_DiscardUnknown() {
+	xxx_messageInfo_FieldError.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_FieldError proto.InternalMessageInfo
+
+func (m *FieldError) GetField() string {
+	if m != nil {
+		return m.Field
+	}
+	return ""
+}
+
+func (m *FieldError) GetMessage() string {
+	if m != nil {
+		return m.Message
+	}
+	return ""
+}
+
+type Error struct {
+	Code                 string        `protobuf:"bytes,1,opt,name=code,proto3" json:"code,omitempty"`
+	Message              string        `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	Fields               []*FieldError `protobuf:"bytes,3,rep,name=fields,proto3" json:"fields,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}      `json:"-"`
+	XXX_unrecognized     []byte        `json:"-"`
+	XXX_sizecache        int32         `json:"-"`
+}
+
+func (m *Error) Reset()         { *m = Error{} }
+func (m *Error) String() string { return proto.CompactTextString(m) }
+func (*Error) ProtoMessage()    {}
+func (*Error) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b205b526963b93a7, []int{6}
+}
+
+func (m *Error) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Error.Unmarshal(m, b)
+}
+func (m *Error) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Error.Marshal(b, m, deterministic)
+}
+func (m *Error) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Error.Merge(m, src)
+}
+func (m *Error) XXX_Size() int {
+	return xxx_messageInfo_Error.Size(m)
+}
+func (m *Error) XXX_DiscardUnknown() {
+	xxx_messageInfo_Error.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Error proto.InternalMessageInfo
+
+func (m *Error) GetCode() string {
+	if m != nil {
+		return m.Code
+	}
+	return ""
+}
+
+func (m *Error) GetMessage() string {
+	if m != nil {
+		return m.Message
+	}
+	return ""
+}
+
+func (m *Error) GetFields() []*FieldError {
+	if m != nil {
+		return m.Fields
+	}
+	return nil
+}
+
+type ListServersRequest struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ListServersRequest) Reset()         { *m = ListServersRequest{} }
+func (m *ListServersRequest) String() string { return proto.CompactTextString(m) }
+func (*ListServersRequest) ProtoMessage()    {}
+func (*ListServersRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b205b526963b93a7, []int{7}
+}
+
+func (m *ListServersRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListServersRequest.Unmarshal(m, b)
+}
+func (m *ListServersRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListServersRequest.Marshal(b, m, deterministic)
+}
+func (m *ListServersRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListServersRequest.Merge(m, src)
+}
+func (m *ListServersRequest) XXX_Size() int {
+	return xxx_messageInfo_ListServersRequest.Size(m)
+}
+func (m *ListServersRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListServersRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListServersRequest proto.InternalMessageInfo
+
+type GetServerRequest struct {
+	Alias                string   `protobuf:"bytes,1,opt,name=alias,proto3" json:"alias,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GetServerRequest) Reset()         { *m = GetServerRequest{} }
+func (m *GetServerRequest) String() string { return proto.CompactTextString(m) }
+func (*GetServerRequest) ProtoMessage()    {}
+func (*GetServerRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b205b526963b93a7, []int{8}
+}
+
+func (m *GetServerRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GetServerRequest.Unmarshal(m, b)
+}
+func (m *GetServerRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GetServerRequest.Marshal(b, m, deterministic)
+}
+func (m *GetServerRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetServerRequest.Merge(m, src)
+}
+func (m *GetServerRequest) XXX_Size() int {
+	return xxx_messageInfo_GetServerRequest.Size(m)
+}
+func (m *GetServerRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetServerRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetServerRequest proto.InternalMessageInfo
+
+func (m *GetServerRequest) GetAlias() string {
+	if m != nil {
+		return m.Alias
+	}
+	return ""
+}
+
+type RemoveRequest struct {
+	Alias                string   `protobuf:"bytes,1,opt,name=alias,proto3" json:"alias,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *RemoveRequest) Reset()         { *m = RemoveRequest{} }
+func (m *RemoveRequest) String() string { return proto.CompactTextString(m) }
+func (*RemoveRequest) ProtoMessage()    {}
+func (*RemoveRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b205b526963b93a7, []int{9}
+}
+
+func (m *RemoveRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RemoveRequest.Unmarshal(m, b)
+}
+func (m *RemoveRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RemoveRequest.Marshal(b, m, deterministic)
+}
+func (m *RemoveRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RemoveRequest.Merge(m, src)
+}
+func (m *RemoveRequest) XXX_Size() int {
+	return xxx_messageInfo_RemoveRequest.Size(m)
+}
+func (m *RemoveRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_RemoveRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RemoveRequest proto.InternalMessageInfo
+
+func (m *RemoveRequest) GetAlias() string {
+	if m != nil {
+		return m.Alias
+	}
+	return ""
+}
+
+type RemoveResponse struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *RemoveResponse) Reset()         { *m = RemoveResponse{} }
+func (m *RemoveResponse) String() string { return proto.CompactTextString(m) }
+func (*RemoveResponse) ProtoMessage()    {}
+func (*RemoveResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b205b526963b93a7, []int{10}
+}
+
+func (m *RemoveResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RemoveResponse.Unmarshal(m, b)
+}
+func (m *RemoveResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RemoveResponse.Marshal(b, m, deterministic)
+}
+func (m *RemoveResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RemoveResponse.Merge(m, src)
+}
+func (m *RemoveResponse) XXX_Size() int {
+	return xxx_messageInfo_RemoveResponse.Size(m)
+}
+func (m *RemoveResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_RemoveResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RemoveResponse proto.InternalMessageInfo
+
+type WatchHealthRequest struct {
+	// intervalSeconds is ignored, streams follow the probes of the server
+	IntervalSeconds      int64    `protobuf:"varint,1,opt,name=intervalSeconds,proto3" json:"intervalSeconds,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *WatchHealthRequest) Reset()         { *m = WatchHealthRequest{} }
+func (m *WatchHealthRequest) String() string { return proto.CompactTextString(m) }
+func (*WatchHealthRequest) ProtoMessage()    {}
+func (*WatchHealthRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b205b526963b93a7, []int{11}
+}
+
+func (m *WatchHealthRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_WatchHealthRequest.Unmarshal(m, b)
+}
+func (m *WatchHealthRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_WatchHealthRequest.Marshal(b, m, deterministic)
+}
+func (m *WatchHealthRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_WatchHealthRequest.Merge(m, src)
+}
+func (m *WatchHealthRequest) XXX_Size() int {
+	return xxx_messageInfo_WatchHealthRequest.Size(m)
+}
+func (m *WatchHealthRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_WatchHealthRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_WatchHealthRequest proto.InternalMessageInfo
+
+func (m *WatchHealthRequest) GetIntervalSeconds() int64 {
+	if m != nil {
+		return m.IntervalSeconds
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterType((*CodeServerStatus)(nil), "healthproto.CodeServerStatus")
 	proto.RegisterType((*HealthCheck)(nil), "healthproto.HealthCheck")
+	proto.RegisterType((*Server)(nil), "healthproto.Server")
+	proto.RegisterType((*RewriteRule)(nil), "healthproto.RewriteRule")
+	proto.RegisterType((*ServerList)(nil), "healthproto.ServerList")
+	proto.RegisterType((*FieldError)(nil), "healthproto.FieldError")
+	proto.RegisterType((*Error)(nil), "healthproto.Error")
+	proto.RegisterType((*ListServersRequest)(nil), "healthproto.ListServersRequest")
+	proto.RegisterType((*GetServerRequest)(nil), "healthproto.GetServerRequest")
+	proto.RegisterType((*RemoveRequest)(nil), "healthproto.RemoveRequest")
+	proto.RegisterType((*RemoveResponse)(nil), "healthproto.RemoveResponse")
+	proto.RegisterType((*WatchHealthRequest)(nil), "healthproto.WatchHealthRequest")
 }
 
 func init() { proto.RegisterFile("healthproto.proto", fileDescriptor_b205b526963b93a7) }
 
 var fileDescriptor_b205b526963b93a7 = []byte{
-	// 198 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0x12, 0xcc, 0x48, 0x4d, 0xcc,
-	0x29, 0xc9, 0x28, 0x28, 0xca, 0x2f, 0xc9, 0xd7, 0x03, 0x93, 0x42, 0xdc, 0x48, 0x42, 0x4a, 0x0d,
-	0x8c, 0x5c, 0x02, 0xce, 0xf9, 0x29, 0xa9, 0xc1, 0xa9, 0x45, 0x65, 0xa9, 0x45, 0xc1, 0x25, 0x89,
-	0x25, 0xa5, 0xc5, 0x42, 0x42, 0x5c, 0x2c, 0x05, 0xf9, 0x45, 0x25, 0x12, 0x8c, 0x0a, 0x8c, 0x1a,
-	0xcc, 0x41, 0x60, 0xb6, 0x90, 0x08, 0x17, 0x6b, 0x71, 0x49, 0x62, 0x49, 0xaa, 0x04, 0x93, 0x02,
-	0xa3, 0x06, 0x67, 0x10, 0x84, 0x23, 0x24, 0xc0, 0xc5, 0x5c, 0x5a, 0x94, 0x23, 0xc1, 0x0c, 0x16,
-	0x03, 0x31, 0x41, 0xea, 0x12, 0x73, 0x32, 0x13, 0x8b, 0x25, 0x58, 0x20, 0xea, 0xc0, 0x1c, 0x21,
-	0x29, 0x2e, 0x0e, 0x30, 0x23, 0x34, 0xc8, 0x47, 0x82, 0x15, 0x2c, 0x01, 0xe7, 0x2b, 0x55, 0x70,
-	0x71, 0x7b, 0x80, 0x5d, 0xe4, 0x9c, 0x91, 0x9a, 0x9c, 0x2d, 0xa4, 0xc1, 0xc5, 0x9f, 0x0c, 0x77,
-	0x50, 0x40, 0x51, 0x7e, 0x45, 0x25, 0xd8, 0x1d, 0x9c, 0x41, 0xe8, 0xc2, 0x42, 0xf6, 0x5c, 0xdc,
-	0x08, 0xa1, 0x62, 0x09, 0x26, 0x05, 0x66, 0x0d, 0x6e, 0x23, 0x59, 0x3d, 0x64, 0x1f, 0xa3, 0x7b,
-	0x2d, 0x08, 0x59, 0x47, 0x12, 0x1b, 0x58, 0x91, 0x31, 0x20, 0x00, 0x00, 0xff, 0xff, 0xf3, 0x04,
-	0xc8, 0x4b, 0x25, 0x01, 0x00, 0x00,
+	// 568 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x7c, 0x54, 0x4f, 0x6f, 0xd3, 0x4e,
+	0x10, 0x95, 0xe3, 0x34, 0x6d, 0xc6, 0xfa, 0xfd, 0x1a, 0x86, 0x8a, 0x5a, 0x06, 0x44, 0x64, 0x09,
+	0xc9, 0x17, 0x0a, 0x2a, 0xdc, 0x40, 0x54, 0xa8, 0x82, 0x22, 0xd4, 0x43, 0xb5, 0x15, 0xe2, 0xbc,
+	0x38, 0x93, 0xda, 0xc2, 0x89, 0xc3, 0xee, 0x26, 0x84, 0x1b, 0x5f, 0x01, 0x3e, 0x21, 0x1f, 0x05,
+	0xed, 0x1f, 0x27, 0x6b, 0x37, 0x70, 0x89, 0x66, 0x9e, 0x67, 0xec, 0xf7, 0xde, 0xcc, 0x04, 0xee,
+	0x14, 0xc4, 0x2b, 0x55, 0x2c, 0x44, 0xad, 0xea, 0x13, 0xf3, 0x8b, 0x91, 0x07, 0xa5, 0x3f, 0x02,
+	0x18, 0x9d, 0xd7, 0x13, 0xba, 0x26, 0xb1, 0x22, 0x71, 0xad, 0xb8, 0x5a, 0x4a, 0x44, 0xe8, 0x2f,
+	0x6a, 0xa1, 0xe2, 0x60, 0x1c, 0x64, 0x21, 0x33, 0x31, 0x1e, 0xc1, 0x9e, 0x54, 0x5c, 0x51, 0xdc,
+	0x1b, 0x07, 0xd9, 0x90, 0xd9, 0x04, 0x47, 0x10, 0x2e, 0x45, 0x15, 0x87, 0x06, 0xd3, 0xa1, 0xae,
+	0xe3, 0x55, 0xc9, 0x65, 0xdc, 0xb7, 0x75, 0x26, 0xc1, 0x04, 0x0e, 0x4c, 0xf0, 0x91, 0x5d, 0xc6,
+	0x7b, 0xe6, 0xc1, 0x26, 0x4f, 0xd7, 0x10, 0xbd, 0x37, 0x8c, 0xce, 0x0b, 0xca, 0xbf, 0x60, 0x06,
+	0x87, 0xf9, 0x86, 0xd0, 0x95, 0xa8, 0xd7, 0xdf, 0x0d, 0x8f, 0x21, 0xeb, 0xc2, 0x78, 0x06, 0xd1,
+	0x16, 0x92, 0x71, 0x6f, 0x1c, 0x66, 0xd1, 0xe9, 0xc3, 0x13, 0x5f, 0x71, 0x57, 0x1a, 0xf3, 0x3b,
+	0xd2, 0x5f, 0x01, 0x0c, 0x6c, 0x6c, 0x24, 0x73, 0x55, 0xb8, 0x4f, 0x99, 0x78, 0x2b, 0xa5, 0xe7,
+	0x4b, 0x69, 0xcc, 0x09, 0x3d, 0x73, 0x4e, 0x61, 0x5f, 0xd0, 0x37, 0x51, 0x2a, 0x8a, 0xfb, 0x86,
+	0x45, 0xdc, 0x62, 0xc1, 0xec, 0x33, 0xb6, 0xac, 0x88, 0x35, 0x85, 0x78, 0x0f, 0x06, 0x32, 0x2f,
+	0x68, 0x46, 0xce, 0x10, 0x97, 0xa5, 0x3f, 0x03, 0x88, 0xbc, 0x06, 0xfd, 0xbd, 0x39, 0x9f, 0x51,
+	0xc3, 0x4c, 0xc7, 0x38, 0x86, 0x48, 0x2a, 0x51, 0x2e, 0xae, 0x04, 0x4d, 0xcb, 0xb5, 0xe3, 0xe7,
+	0x43, 0x9a, 0xfb, 0x8c, 0xab, 0xbc, 0x70, 0xa3, 0xb1, 0x09, 0xc6, 0x9a, 0xe7, 0xa2, 0xe2, 0x39,
+	0xb9, 0xf1, 0x34, 0x29, 0x3e, 0x80, 0x21, 0x9f, 0x4c, 0xdc, 0xfb, 0x2c, 0xa1, 0x2d, 0x90, 0xbe,
+	0x04, 0xb0, 0x3e, 0x5d, 0x96, 0x52, 0xe1, 0x13, 0xd8, 0x97, 0xce, 0xf3, 0xc0, 0xa8, 0xbd, 0xdb,
+	0x52, 0x6b, 0x2b, 0x59, 0x53, 0x93, 0xbe, 0x02, 0x78, 0x57, 0x52, 0x35, 0x79, 0x2b, 0x44, 0x2d,
+	0x34, 0xb1, 0xa9, 0xce, 0x9c, 0x1e, 0x9b, 0x68, 0x62, 0x33, 0x92, 0x92, 0xdf, 0x34, 0xfb, 0xd5,
+	0xa4, 0xe9, 0x14, 0xf6, 0x6c, 0x23, 0x42, 0x5f, 0xcf, 0xae, 0xf1, 0x41, 0xc7, 0x7f, 0x6f, 0xc3,
+	0xa7, 0x30, 0x30, 0x6f, 0x96, 0x71, 0x68, 0x28, 0x1e, 0xb7, 0x28, 0x6e, 0xf9, 0x30, 0x57, 0x96,
+	0x1e, 0x01, 0x6a, 0x71, 0x6e, 0x35, 0x18, 0x7d, 0x5d, 0x92, 0x54, 0x69, 0x06, 0xa3, 0x0b, 0x72,
+	0xa0, 0xc3, 0xb6, 0x6b, 0x11, 0x78, 0x6b, 0x91, 0x3e, 0x86, 0xff, 0x18, 0xcd, 0xea, 0x15, 0xfd,
+	0xbb, 0x6c, 0x04, 0xff, 0x37, 0x65, 0x72, 0x51, 0xcf, 0x25, 0xa5, 0xaf, 0x01, 0x3f, 0xe9, 0xe1,
+	0xd8, 0x1b, 0x68, 0xba, 0x33, 0x38, 0x2c, 0xe7, 0x8a, 0xc4, 0x8a, 0x57, 0xd7, 0x94, 0xd7, 0xf3,
+	0x89, 0x74, 0xd7, 0xd8, 0x85, 0x4f, 0x7f, 0xf7, 0xe0, 0xf0, 0xbc, 0x73, 0x19, 0x17, 0x10, 0x79,
+	0x62, 0xf0, 0x51, 0x4b, 0xfc, 0x6d, 0x99, 0xc9, 0xf1, 0x8e, 0x01, 0x9a, 0x51, 0x9f, 0xc1, 0x70,
+	0xa3, 0x1f, 0xdb, 0xa7, 0xd5, 0xf5, 0x25, 0xd9, 0xb5, 0x05, 0xf8, 0x02, 0x0e, 0x18, 0xdd, 0x94,
+	0x52, 0x91, 0xc0, 0x5d, 0x05, 0xbb, 0xbb, 0xde, 0xc0, 0xc0, 0xba, 0x84, 0x49, 0xe7, 0x90, 0x3c,
+	0x87, 0x93, 0xfb, 0x3b, 0x9f, 0x59, 0x5b, 0xf1, 0x03, 0x44, 0x9e, 0xad, 0x1d, 0x0b, 0x6e, 0x1b,
+	0x9e, 0xb4, 0x2f, 0xd6, 0xfb, 0x43, 0x7a, 0x16, 0x7c, 0x1e, 0x18, 0xf0, 0xf9, 0x9f, 0x01, 0x00,
+	0x9c, 0xa1, 0x8b, 0x52, 0x4d, 0x05, 0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// CodeServerProxyClient is the client API for CodeServerProxy service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type CodeServerProxyClient interface {
+	ListServers(ctx context.Context, in *ListServersRequest, opts ...grpc.CallOption) (*ServerList, error)
+	GetServer(ctx context.Context, in *GetServerRequest, opts ...grpc.CallOption) (*Server, error)
+	Register(ctx context.Context, in *Server, opts ...grpc.CallOption) (*Server, error)
+	Remove(ctx context.Context, in *RemoveRequest, opts ...grpc.CallOption) (*RemoveResponse, error)
+	// WatchHealth streams the health of code-servers whenever it changes
+	WatchHealth(ctx context.Context, in *WatchHealthRequest, opts ...grpc.CallOption) (CodeServerProxy_WatchHealthClient, error)
+}
+
+type codeServerProxyClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewCodeServerProxyClient(cc *grpc.ClientConn) CodeServerProxyClient {
+	return &codeServerProxyClient{cc}
+}
+
+func (c *codeServerProxyClient) ListServers(ctx context.Context, in *ListServersRequest, opts ...grpc.CallOption) (*ServerList, error) {
+	out := new(ServerList)
+	err := c.cc.Invoke(ctx, "/healthproto.CodeServerProxy/ListServers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *codeServerProxyClient) GetServer(ctx context.Context, in *GetServerRequest, opts ...grpc.CallOption) (*Server, error) {
+	out := new(Server)
+	err := c.cc.Invoke(ctx, "/healthproto.CodeServerProxy/GetServer", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *codeServerProxyClient) Register(ctx context.Context, in *Server, opts ...grpc.CallOption) (*Server, error) {
+	out := new(Server)
+	err := c.cc.Invoke(ctx, "/healthproto.CodeServerProxy/Register", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *codeServerProxyClient) Remove(ctx context.Context, in *RemoveRequest, opts ...grpc.CallOption) (*RemoveResponse, error) {
+	out := new(RemoveResponse)
+	err := c.cc.Invoke(ctx, "/healthproto.CodeServerProxy/Remove", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *codeServerProxyClient) WatchHealth(ctx context.Context, in *WatchHealthRequest, opts ...grpc.CallOption) (CodeServerProxy_WatchHealthClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_CodeServerProxy_serviceDesc.Streams[0], "/healthproto.CodeServerProxy/WatchHealth", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &codeServerProxyWatchHealthClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type CodeServerProxy_WatchHealthClient interface {
+	Recv() (*HealthCheck, error)
+	grpc.ClientStream
+}
+
+type codeServerProxyWatchHealthClient struct {
+	grpc.ClientStream
+}
+
+func (x *codeServerProxyWatchHealthClient) Recv() (*HealthCheck, error) {
+	m := new(HealthCheck)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// CodeServerProxyServer is the server API for CodeServerProxy service.
+type CodeServerProxyServer interface {
+	ListServers(context.Context, *ListServersRequest) (*ServerList, error)
+	GetServer(context.Context, *GetServerRequest) (*Server, error)
+	Register(context.Context, *Server) (*Server, error)
+	Remove(context.Context, *RemoveRequest) (*RemoveResponse, error)
+	// WatchHealth streams the health of code-servers whenever it changes
+	WatchHealth(*WatchHealthRequest, CodeServerProxy_WatchHealthServer) error
+}
+
+func RegisterCodeServerProxyServer(s *grpc.Server, srv CodeServerProxyServer) {
+	s.RegisterService(&_CodeServerProxy_serviceDesc, srv)
+}
+
+func _CodeServerProxy_ListServers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListServersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CodeServerProxyServer).ListServers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/healthproto.CodeServerProxy/ListServers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CodeServerProxyServer).ListServers(ctx, req.(*ListServersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CodeServerProxy_GetServer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetServerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CodeServerProxyServer).GetServer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/healthproto.CodeServerProxy/GetServer",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CodeServerProxyServer).GetServer(ctx, req.(*GetServerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CodeServerProxy_Register_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Server)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CodeServerProxyServer).Register(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/healthproto.CodeServerProxy/Register",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CodeServerProxyServer).Register(ctx, req.(*Server))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CodeServerProxy_Remove_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemoveRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CodeServerProxyServer).Remove(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/healthproto.CodeServerProxy/Remove",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CodeServerProxyServer).Remove(ctx, req.(*RemoveRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CodeServerProxy_WatchHealth_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchHealthRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CodeServerProxyServer).WatchHealth(m, &codeServerProxyWatchHealthServer{stream})
+}
+
+type CodeServerProxy_WatchHealthServer interface {
+	Send(*HealthCheck) error
+	grpc.ServerStream
+}
+
+type codeServerProxyWatchHealthServer struct {
+	grpc.ServerStream
+}
+
+func (x *codeServerProxyWatchHealthServer) Send(m *HealthCheck) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+var _CodeServerProxy_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "healthproto.CodeServerProxy",
+	HandlerType: (*CodeServerProxyServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListServers",
+			Handler:    _CodeServerProxy_ListServers_Handler,
+		},
+		{
+			MethodName: "GetServer",
+			Handler:    _CodeServerProxy_GetServer_Handler,
+		},
+		{
+			MethodName: "Register",
+			Handler:    _CodeServerProxy_Register_Handler,
+		},
+		{
+			MethodName: "Remove",
+			Handler:    _CodeServerProxy_Remove_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchHealth",
+			Handler:       _CodeServerProxy_WatchHealth_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "healthproto.proto",
 }
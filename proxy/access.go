@@ -0,0 +1,282 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Sinks of the access log
+const (
+	AccessLogStdout = "stdout"
+	AccessLogFile   = "file"
+)
+
+// Formats of the access log
+const (
+	AccessLogJSON     = "json"
+	AccessLogCombined = "combined"
+)
+
+// DefaultAccessLogFile is the file of the access log if none is configured
+const DefaultAccessLogFile = "access.log"
+
+// requestIDHeader carries the ID of a request to code-servers and back to
+// browsers
+const requestIDHeader = "X-Request-Id"
+
+// clfTime is the time layout of the combined log format
+const clfTime = "02/Jan/2006:15:04:05 -0700"
+
+// AccessLog configures the log of every request served
+type AccessLog struct {
+	// Sink is stdout or file, the access log is disabled if empty
+	Sink string `yaml:"sink,omitempty"`
+	// File is the file of the file sink, DefaultAccessLogFile if empty
+	File string `yaml:"file,omitempty"`
+	// Format is json or combined, json if empty
+	Format string `yaml:"format,omitempty"`
+	// MaxSize is the size in bytes a file is rotated at, DefaultMaxFileSize if zero
+	MaxSize int64 `yaml:"maxSize,omitempty"`
+	// MaxFiles is the number of rotated files kept, DefaultMaxFiles if zero
+	MaxFiles int `yaml:"maxFiles,omitempty"`
+}
+
+// AccessEntry is the access log entry of a request. Websocket sessions are
+// logged once they end.
+type AccessEntry struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"requestId"`
+	ClientIP  string    `json:"clientIP"`
+	User      string    `json:"user,omitempty"`
+	Method    string    `json:"method"`
+	URI       string    `json:"uri"`
+	Proto     string    `json:"proto"`
+	Status    int       `json:"status"`
+	// Bytes is the size of the response body, or of the messages sent to
+	// the browser by a websocket session
+	Bytes     int64  `json:"bytes"`
+	Referer   string `json:"referer,omitempty"`
+	UserAgent string `json:"userAgent,omitempty"`
+	// Server is the alias of the project the request was routed to
+	Server  string `json:"server,omitempty"`
+	Backend string `json:"backend,omitempty"`
+	// Duration is the time in seconds from receiving the request to the end
+	// of the response or websocket session
+	Duration float64 `json:"duration"`
+	Session  string  `json:"session,omitempty"`
+	// EndReason is the reason a websocket session ended
+	EndReason string `json:"endReason,omitempty"`
+}
+
+// accessLogger writes access log entries
+type accessLogger struct {
+	format string
+	out    io.WriteCloser
+	logger *logrus.Logger
+
+	// mu keeps concurrent entries on separate lines
+	mu sync.Mutex
+}
+
+// nopCloser keeps stdout open when the access log is closed
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// newAccessLogger opens the sink of the access log, it returns nil if the
+// access log is disabled
+func newAccessLogger(c AccessLog, logger *logrus.Logger) (*accessLogger, error) {
+	a := &accessLogger{format: c.Format, logger: logger}
+	switch c.Format {
+	case "":
+		a.format = AccessLogJSON
+	case AccessLogJSON, AccessLogCombined:
+	default:
+		return nil, fmt.Errorf("Unknown access log format: %s", c.Format)
+	}
+
+	switch c.Sink {
+	case "":
+		return nil, nil
+	case AccessLogStdout:
+		a.out = nopCloser{os.Stdout}
+	case AccessLogFile:
+		file := c.File
+		if file == "" {
+			file = DefaultAccessLogFile
+		}
+		out, err := openRotatingWriter(file, c.MaxSize, c.MaxFiles)
+		if err != nil {
+			return nil, err
+		}
+		a.out = out
+	default:
+		return nil, fmt.Errorf("Unknown access log sink: %s", c.Sink)
+	}
+	return a, nil
+}
+
+// log writes e in the configured format
+func (a *accessLogger) log(e AccessEntry) {
+	if a == nil {
+		return
+	}
+
+	var line []byte
+	if a.format == AccessLogCombined {
+		line = []byte(combined(e))
+	} else {
+		b, err := json.Marshal(e)
+		if err != nil {
+			a.logger.Errorf("Failed to marshal access log entry: %v", err)
+			return
+		}
+		line = b
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.out.Write(append(line, '\n')); err != nil {
+		a.logger.Errorf("Failed to write access log: %v", err)
+	}
+}
+
+// close closes the sink of the access log
+func (a *accessLogger) close() error {
+	if a == nil {
+		return nil
+	}
+	return a.out.Close()
+}
+
+// combined formats e in the combined log format, followed by the fields it
+// lacks as key="value" pairs
+func combined(e AccessEntry) string {
+	return fmt.Sprintf(`%s - %s [%s] "%s %s %s" %d %d %q %q request_id=%q server=%q backend=%q duration=%.3f session=%q end_reason=%q`,
+		orDash(e.ClientIP), orDash(e.User), e.Time.Format(clfTime), e.Method, e.URI, e.Proto,
+		e.Status, e.Bytes, orDash(e.Referer), orDash(e.UserAgent),
+		e.RequestID, e.Server, e.Backend, e.Duration, e.Session, e.EndReason)
+}
+
+// orDash returns s, or - if s is empty
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// accessRecorder records the response of a request for the access log. The
+// handlers add what only they know, e.g. the code-server.
+type accessRecorder struct {
+	http.ResponseWriter
+	entry    AccessEntry
+	hijacked bool
+}
+
+type accessRecorderKey struct{}
+
+// accessRecorderOf returns the recorder of r, nil if neither the access log
+// nor tracing is enabled
+func accessRecorderOf(r *http.Request) *accessRecorder {
+	rec, _ := r.Context().Value(accessRecorderKey{}).(*accessRecorder)
+	return rec
+}
+
+// WriteHeader sets X-Request-Id last, so it isn't repeated by the headers
+// copied from code-servers
+func (rec *accessRecorder) WriteHeader(status int) {
+	if rec.entry.Status == 0 {
+		rec.entry.Status = status
+		rec.Header().Set(requestIDHeader, rec.entry.RequestID)
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *accessRecorder) Write(b []byte) (int, error) {
+	if rec.entry.Status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.entry.Bytes += int64(n)
+	return n, err
+}
+
+func (rec *accessRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("Response writer can not be hijacked")
+	}
+
+	conn, brw, err := h.Hijack()
+	if err == nil {
+		rec.hijacked = true
+	}
+	return conn, brw, err
+}
+
+// resolved records the code-server of the request
+func (rec *accessRecorder) resolved(res Resolution) {
+	if rec == nil {
+		return
+	}
+	rec.entry.Server = res.Server.Alias
+	rec.entry.Backend = res.BackendURL
+}
+
+// sessionEnded records the websocket session s of the request
+func (rec *accessRecorder) sessionEnded(s *session) {
+	if rec == nil {
+		return
+	}
+	rec.entry.Session = s.id
+	rec.entry.EndReason = s.endReason
+	rec.entry.Bytes = s.toFrontend.transfer().Bytes
+}
+
+// rejected records the reason a websocket session was refused after its
+// handshake
+func (rec *accessRecorder) rejected(reason string) {
+	if rec == nil {
+		return
+	}
+	rec.entry.EndReason = reason
+}
+
+// newRequestID returns a random request ID
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// finish completes the entry of the request started at start once it was
+// served
+func (rec *accessRecorder) finish(start time.Time) {
+	// The handshake responses of websockets are written to the hijacked
+	// connection
+	switch {
+	case rec.entry.Status != 0:
+	case rec.hijacked:
+		rec.entry.Status = http.StatusSwitchingProtocols
+	default:
+		rec.entry.Status = http.StatusOK
+	}
+	rec.entry.Duration = time.Since(start).Seconds()
+}
@@ -0,0 +1,180 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+// useAccessLog logs the requests of the proxy to file in format
+func useAccessLog(file, format string) func(*Proxy) error {
+	return func(p *Proxy) error {
+		p.code.AccessLog = AccessLog{Sink: AccessLogFile, File: file, Format: format}
+		return nil
+	}
+}
+
+// readAccessLog waits for n entries in the access log file and returns them
+func readAccessLog(t *testing.T, file string, n int) []AccessEntry {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		b, err := ioutil.ReadFile(file)
+		require.NoError(t, err)
+
+		var entries []AccessEntry
+		scanner := bufio.NewScanner(strings.NewReader(string(b)))
+		for scanner.Scan() {
+			var e AccessEntry
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &e))
+			entries = append(entries, e)
+		}
+		if len(entries) >= n {
+			return entries
+		}
+		require.True(t, time.Now().Before(deadline), "%d of %d entries logged", len(entries), n)
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAccessLogRequests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "code-server-proxy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "access.log")
+
+	var backendID string
+	p, wsURL, cleanup := newTestWebsocketProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		backendID = r.Header.Get(requestIDHeader)
+		w.Header().Set(requestIDHeader, "ignored")
+		fmt.Fprint(w, "hello")
+	}, useAccessLog(file, ""), useTrustedProxies("127.0.0.1"))
+	defer cleanup()
+
+	req, err := http.NewRequest("GET", strings.Replace(wsURL, "ws://", "http://", 1)+"/project1/x?y=z", nil)
+	require.NoError(t, err)
+	req.Header.Set(requestIDHeader, "abc123")
+	req.Header.Set("X-Forwarded-User", "alice")
+	req.Header.Set("User-Agent", "test")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	// The request ID is passed on to the code-server and the browser
+	require.Equal(t, "abc123", backendID)
+	require.Equal(t, []string{"abc123"}, resp.Header[requestIDHeader])
+
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "/sessions", nil))
+	require.NotEmpty(t, w.Header().Get(requestIDHeader))
+
+	entries := readAccessLog(t, file, 2)
+	e := entries[0]
+	require.Equal(t, "abc123", e.RequestID)
+	require.Equal(t, "127.0.0.1", e.ClientIP)
+	require.Equal(t, "alice", e.User)
+	require.Equal(t, "GET", e.Method)
+	require.Equal(t, "/project1/x?y=z", e.URI)
+	require.Equal(t, http.StatusOK, e.Status)
+	require.Equal(t, int64(5), e.Bytes)
+	require.Equal(t, "test", e.UserAgent)
+	require.Equal(t, "project1", e.Server)
+	require.Contains(t, e.Backend, "/x?y=z")
+	require.True(t, e.Duration > 0)
+
+	require.Equal(t, w.Header().Get(requestIDHeader), entries[1].RequestID)
+	require.Equal(t, "/sessions", entries[1].URI)
+	require.Empty(t, entries[1].Server)
+}
+
+func TestAccessLogUntrustedProxy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "code-server-proxy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "access.log")
+
+	_, wsURL, cleanup := newTestWebsocketProxy(t, func(w http.ResponseWriter, r *http.Request) {}, useAccessLog(file, ""))
+	defer cleanup()
+
+	// Forwarded headers of untrusted peers are ignored
+	req, err := http.NewRequest("GET", strings.Replace(wsURL, "ws://", "http://", 1)+"/project1/", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Forwarded-For", "10.0.0.1")
+	req.Header.Set("X-Forwarded-User", "alice")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	e := readAccessLog(t, file, 1)[0]
+	require.Equal(t, "127.0.0.1", e.ClientIP)
+	require.Empty(t, e.User)
+}
+
+func TestAccessLogWebsocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "code-server-proxy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "access.log")
+
+	for _, mode := range []string{ModeFrame, ModeRaw} {
+		require.NoError(t, os.RemoveAll(file))
+
+		p, wsURL, cleanup := newTestWebsocketProxy(t, echo(websocket.Upgrader{CheckOrigin: anyOrigin}, nil),
+			useAccessLog(file, ""), useWebsocket(Websocket{Mode: mode}))
+
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL+"/project1/", nil)
+		require.NoError(t, err)
+		require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("hello")))
+		_, _, err = conn.ReadMessage()
+		require.NoError(t, err)
+		conn.Close()
+		waitSessions(t, p)
+
+		e := readAccessLog(t, file, 1)[0]
+		require.Equal(t, http.StatusSwitchingProtocols, e.Status, mode)
+		require.Equal(t, "project1", e.Server, mode)
+		require.NotEmpty(t, e.Session, mode)
+		require.Contains(t, e.EndReason, sideFrontend, mode)
+		require.True(t, e.Bytes > 0, mode)
+
+		cleanup()
+		require.NoError(t, p.Close())
+	}
+}
+
+func TestAccessLogCombined(t *testing.T) {
+	e := AccessEntry{
+		Time:      time.Date(2019, 5, 1, 13, 55, 36, 0, time.UTC),
+		RequestID: "abc123",
+		ClientIP:  "10.0.0.1",
+		Method:    "GET",
+		URI:       "/project1/",
+		Proto:     "HTTP/1.1",
+		Status:    200,
+		Bytes:     5,
+		UserAgent: "Mozilla/5.0",
+		Server:    "project1",
+		Backend:   "http://localhost:8888/",
+		Duration:  0.0123,
+	}
+	require.Equal(t, `10.0.0.1 - - [01/May/2019:13:55:36 +0000] "GET /project1/ HTTP/1.1" 200 5 "-" "Mozilla/5.0" `+
+		`request_id="abc123" server="project1" backend="http://localhost:8888/" duration=0.012 session="" end_reason=""`, combined(e))
+}
+
+func TestInvalidAccessLog(t *testing.T) {
+	for _, a := range []AccessLog{{Sink: "syslog"}, {Sink: AccessLogStdout, Format: "common"}} {
+		_, err := NewProxy(UseLogger(logrus.New()), UseCode(Code{AccessLog: a}))
+		require.Error(t, err, "%+v", a)
+	}
+}
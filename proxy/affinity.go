@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RuleAffinity routes by the alias in the signed affinity cookie
+const RuleAffinity = "affinity"
+
+const (
+	defaultAffinityCookie = "code-server-affinity"
+	affinitySecretSize    = 32
+)
+
+// affinity signs and verifies the cookie which pins a browser to the
+// code-server it entered last
+type affinity struct {
+	cookie string
+	secret []byte
+}
+
+// newAffinity creates the affinity cookie configured in routing
+func newAffinity(routing Routing) (affinity, error) {
+	if routing.AffinitySecret == "" {
+		return affinity{}, errors.New("Affinity cookies require a secret")
+	}
+
+	a := affinity{
+		cookie: routing.AffinityCookie,
+		secret: []byte(routing.AffinitySecret),
+	}
+	if a.cookie == "" {
+		a.cookie = defaultAffinityCookie
+	}
+	return a, nil
+}
+
+// randomSecret generates a secret for affinity cookies
+func randomSecret() (string, error) {
+	b := make([]byte, affinitySecretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("Failed to generate affinity secret: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// sign returns the cookie value of alias
+func (a affinity) sign(alias string) string {
+	return fmt.Sprintf("%s.%s", alias, a.signature(alias))
+}
+
+// verify returns the alias of a cookie value, or false if it isn't signed
+// by a
+func (a affinity) verify(value string) (string, bool) {
+	i := strings.LastIndex(value, ".")
+	if i < 0 {
+		return "", false
+	}
+
+	alias, signature := value[:i], value[i+1:]
+	if !hmac.Equal([]byte(signature), []byte(a.signature(alias))) {
+		return "", false
+	}
+	return alias, true
+}
+
+func (a affinity) signature(alias string) string {
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte(alias))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// affinityResolver routes by the alias of a valid affinity cookie
+type affinityResolver struct {
+	affinity affinity
+}
+
+func (affinityResolver) Name() string { return RuleAffinity }
+
+func (a affinityResolver) Resolve(r *http.Request, routes *RouteTable) (Match, bool) {
+	cookie, err := r.Cookie(a.affinity.cookie)
+	if err != nil {
+		return Match{}, false
+	}
+
+	alias, ok := a.affinity.verify(cookie.Value)
+	if !ok {
+		return Match{}, false
+	}
+
+	return matchAlias(routes, alias, fmt.Sprintf("%s=%s", a.affinity.cookie, alias))
+}
+
+// isNavigation reports whether r loads a page in the browser, as opposed to
+// assets, XHRs or websockets
+func isNavigation(r *http.Request) bool {
+	if r.Method != http.MethodGet || isWebsocket(r) {
+		return false
+	}
+
+	if mode := r.Header.Get("Sec-Fetch-Mode"); mode != "" {
+		return mode == "navigate"
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// setAffinity pins the browser to the code-server of res when it enters a
+// project by its path or alias
+func (p *Proxy) setAffinity(w http.ResponseWriter, r *http.Request, res Resolution) {
+	if res.Rule != RuleAlias && res.Rule != RulePrefix {
+		return
+	}
+	if !isNavigation(r) {
+		return
+	}
+
+	if cookie, err := r.Cookie(p.affinity.cookie); err == nil {
+		if alias, ok := p.affinity.verify(cookie.Value); ok && alias == res.Server.Alias {
+			return
+		}
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     p.affinity.cookie,
+		Value:    p.affinity.sign(res.Server.Alias),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	p.logger.WithFields(logrus.Fields{
+		"path":   requestPath(r),
+		"rule":   res.Rule,
+		"server": res.Server.Alias,
+	}).Info("Set affinity cookie")
+}
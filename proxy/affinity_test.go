@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAffinitySignature(t *testing.T) {
+	a, err := newAffinity(Routing{AffinitySecret: "secret"})
+	require.NoError(t, err)
+	require.Equal(t, defaultAffinityCookie, a.cookie)
+
+	alias, ok := a.verify(a.sign("project1"))
+	require.True(t, ok)
+	require.Equal(t, "project1", alias)
+
+	other, err := newAffinity(Routing{AffinitySecret: "other"})
+	require.NoError(t, err)
+
+	for _, value := range []string{"project1", "project1.", "project2" + a.sign("project1")[8:], other.sign("project1")} {
+		_, ok := a.verify(value)
+		require.False(t, ok, value)
+	}
+
+	_, err = newAffinity(Routing{})
+	require.Error(t, err)
+}
+
+func TestAffinity(t *testing.T) {
+	port, closeBackend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.URL.Path)
+	})
+	defer closeBackend()
+
+	p, err := NewProxy(
+		UseCode(Code{Servers: []Server{
+			{Path: "/a/b/c", Alias: "project1", Port: 9000},
+			{Path: "/a/d/e", Alias: "project2", Port: port},
+		}}),
+		UseLogger(logrus.New()),
+		UseAffinitySecret("secret"),
+	)
+	require.NoError(t, err)
+
+	// Entering a project sets the cookie
+	req := httptest.NewRequest("GET", "/project2/", nil)
+	req.Header.Set("Accept", "text/html")
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	cookies := rr.Result().Cookies()
+	require.Len(t, cookies, 1)
+	require.Equal(t, defaultAffinityCookie, cookies[0].Name)
+	require.True(t, cookies[0].HttpOnly)
+
+	// Assets without Referer follow the cookie
+	req = httptest.NewRequest("GET", "/static/x.js", nil)
+	req.AddCookie(cookies[0])
+	res, err := p.resolve(req)
+	require.NoError(t, err)
+	require.Equal(t, RuleAffinity, res.Rule)
+	require.Equal(t, "project2", res.Server.Alias)
+
+	// The cookie is not set again, nor by assets
+	rr = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/project2/", nil)
+	req.Header.Set("Sec-Fetch-Mode", "navigate")
+	req.AddCookie(cookies[0])
+	p.ServeHTTP(rr, req)
+	require.Empty(t, rr.Result().Cookies())
+
+	rr = httptest.NewRecorder()
+	p.ServeHTTP(rr, httptest.NewRequest("GET", "/project2/static/x.js", nil))
+	require.Empty(t, rr.Result().Cookies())
+
+	// Forged cookies fall back to the Referer
+	req = httptest.NewRequest("GET", "/static/x.js", nil)
+	req.AddCookie(&http.Cookie{Name: defaultAffinityCookie, Value: "project2.forged"})
+	req.Header.Set("Referer", "https://ide.example.com/a/b/c/")
+	res, err = p.resolve(req)
+	require.NoError(t, err)
+	require.Equal(t, RuleReferer, res.Rule)
+	require.Equal(t, "project1", res.Server.Alias)
+}
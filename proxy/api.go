@@ -0,0 +1,390 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/gorilla/mux"
+
+	"github.com/code-server-proxy/healthproto"
+)
+
+const (
+	contentTypeJSON     = "application/json"
+	contentTypeProtobuf = "application/x-protobuf"
+)
+
+// Error codes of the management API
+const (
+	ErrCodeInvalidRequest       = "invalid_request"
+	ErrCodeValidationFailed     = "validation_failed"
+	ErrCodeNotFound             = "not_found"
+	ErrCodeConflict             = "conflict"
+	ErrCodeUnsupportedMediaType = "unsupported_media_type"
+	ErrCodeInternal             = "internal"
+	ErrCodeForbidden            = "forbidden"
+)
+
+// APIError is the structured error returned by the management API
+type APIError struct {
+	Status  int          `json:"-"`
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Fields  []FieldError `json:"fields,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// APIErrorResponse is the JSON body of a failed management API request
+type APIErrorResponse struct {
+	Error *APIError `json:"error"`
+}
+
+// ServerList is the JSON body of the server collection
+type ServerList struct {
+	Servers []Server `json:"servers"`
+}
+
+func (p *Proxy) routeAPI() {
+	api := p.PathPrefix("/api/v1").Subrouter()
+
+	api.HandleFunc("/servers", p.listServersHandler).Methods("GET")
+	api.HandleFunc("/servers", p.requireOrigin(p.createServerHandler)).Methods("POST")
+	api.HandleFunc("/servers/{name}", p.getServerHandler).Methods("GET")
+	api.HandleFunc("/servers/{name}", p.requireOrigin(p.replaceServerHandler)).Methods("PUT")
+	api.HandleFunc("/servers/{name}", p.requireOrigin(p.patchServerHandler)).Methods("PATCH")
+	api.HandleFunc("/servers/{name}", p.requireOrigin(p.deleteServerHandler)).Methods("DELETE")
+}
+
+// listServersHandler handles GET /api/v1/servers
+func (p *Proxy) listServersHandler(w http.ResponseWriter, r *http.Request) {
+	servers := p.servers()
+
+	list := healthproto.ServerList{}
+	for _, s := range servers {
+		list.Servers = append(list.Servers, serverToProto(s))
+	}
+
+	p.writeAPIResponse(w, r, http.StatusOK, ServerList{Servers: servers}, &list)
+}
+
+// getServerHandler handles GET /api/v1/servers/{name}
+func (p *Proxy) getServerHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	s, ok := p.server(name)
+	if !ok {
+		p.writeAPIError(w, r, registryError(ErrServerNotFound, Server{Alias: name}))
+		return
+	}
+
+	p.writeAPIResponse(w, r, http.StatusOK, s, serverToProto(s))
+}
+
+// createServerHandler handles POST /api/v1/servers
+func (p *Proxy) createServerHandler(w http.ResponseWriter, r *http.Request) {
+	s, aerr := decodeServer(r)
+	if aerr != nil {
+		p.writeAPIError(w, r, aerr)
+		return
+	}
+
+	if err := p.addServer(s); err != nil {
+		p.writeAPIError(w, r, registryError(err, s))
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/servers/%s", s.Alias))
+	p.writeAPIResponse(w, r, http.StatusCreated, s, serverToProto(s))
+}
+
+// replaceServerHandler handles PUT /api/v1/servers/{name}
+func (p *Proxy) replaceServerHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	s, aerr := decodeServer(r)
+	if aerr != nil {
+		p.writeAPIError(w, r, aerr)
+		return
+	}
+
+	if err := p.updateServer(name, s); err != nil {
+		p.writeAPIError(w, r, registryError(err, s))
+		return
+	}
+
+	p.writeAPIResponse(w, r, http.StatusOK, s, serverToProto(s))
+}
+
+// patchServerHandler handles PATCH /api/v1/servers/{name}. Only the fields
+// set in the request body are changed, an empty list of rewrite rules
+// restores DefaultRewrites.
+func (p *Proxy) patchServerHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	patch, aerr := decodeServer(r)
+	if aerr != nil {
+		p.writeAPIError(w, r, aerr)
+		return
+	}
+
+	s, ok := p.server(name)
+	if !ok {
+		p.writeAPIError(w, r, registryError(ErrServerNotFound, Server{Alias: name}))
+		return
+	}
+
+	if patch.Path != "" {
+		s.Path = patch.Path
+	}
+	if patch.Alias != "" {
+		s.Alias = patch.Alias
+	}
+	if patch.Port != 0 {
+		s.Port = patch.Port
+	}
+	if patch.Scheme != "" {
+		s.Scheme = patch.Scheme
+	}
+	if patch.Rewrite != nil {
+		s.Rewrite = patch.Rewrite
+		if len(s.Rewrite) == 0 {
+			s.Rewrite = nil
+		}
+	}
+
+	if err := p.updateServer(name, s); err != nil {
+		p.writeAPIError(w, r, registryError(err, s))
+		return
+	}
+
+	p.writeAPIResponse(w, r, http.StatusOK, s, serverToProto(s))
+}
+
+// deleteServerHandler handles DELETE /api/v1/servers/{name}
+func (p *Proxy) deleteServerHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	if err := p.removeServer(name); err != nil {
+		p.writeAPIError(w, r, registryError(err, Server{Alias: name}))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decodeServer decodes a server from a JSON or protobuf request body
+func decodeServer(r *http.Request) (Server, *APIError) {
+	s := Server{}
+
+	mediaType := contentTypeJSON
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		mt, _, err := mime.ParseMediaType(ct)
+		if err != nil {
+			return s, &APIError{
+				Status:  http.StatusUnsupportedMediaType,
+				Code:    ErrCodeUnsupportedMediaType,
+				Message: fmt.Sprintf("Invalid Content-Type %q", ct),
+			}
+		}
+		mediaType = mt
+	}
+
+	switch mediaType {
+	case contentTypeJSON:
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&s); err != nil {
+			return s, &APIError{
+				Status:  http.StatusBadRequest,
+				Code:    ErrCodeInvalidRequest,
+				Message: fmt.Sprintf("Failed to decode request body: %v", err),
+			}
+		}
+	case contentTypeProtobuf:
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return s, &APIError{
+				Status:  http.StatusBadRequest,
+				Code:    ErrCodeInvalidRequest,
+				Message: fmt.Sprintf("Failed to read request body: %v", err),
+			}
+		}
+
+		m := healthproto.Server{}
+		if uerr := proto.Unmarshal(data, &m); uerr != nil {
+			return s, &APIError{
+				Status:  http.StatusBadRequest,
+				Code:    ErrCodeInvalidRequest,
+				Message: fmt.Sprintf("Failed to decode request body: %v", uerr),
+			}
+		}
+		s = serverFromProto(&m)
+	default:
+		return s, &APIError{
+			Status:  http.StatusUnsupportedMediaType,
+			Code:    ErrCodeUnsupportedMediaType,
+			Message: fmt.Sprintf("Content-Type %s is not supported", mediaType),
+		}
+	}
+
+	return s, nil
+}
+
+// registryError converts an error of the server registry about s to an API error
+func registryError(err error, s Server) *APIError {
+	switch err {
+	case ErrServerNotFound:
+		return &APIError{
+			Status:  http.StatusNotFound,
+			Code:    ErrCodeNotFound,
+			Message: fmt.Sprintf("Code-server %s doesn't exist", s.Alias),
+		}
+	case ErrAliasInUse:
+		return &APIError{
+			Status:  http.StatusConflict,
+			Code:    ErrCodeConflict,
+			Message: fmt.Sprintf("Name %s is in use", s.Alias),
+		}
+	case ErrPortInUse:
+		return &APIError{
+			Status:  http.StatusConflict,
+			Code:    ErrCodeConflict,
+			Message: fmt.Sprintf("Port %d is in use", s.Port),
+		}
+	}
+
+	switch e := err.(type) {
+	case *APIError:
+		return e
+	case *RouteConflictError:
+		return &APIError{
+			Status:  http.StatusConflict,
+			Code:    ErrCodeConflict,
+			Message: e.Error(),
+		}
+	case *ValidationError:
+		return &APIError{
+			Status:  http.StatusUnprocessableEntity,
+			Code:    ErrCodeValidationFailed,
+			Message: e.Error(),
+			Fields:  e.Fields,
+		}
+	}
+
+	return &APIError{
+		Status:  http.StatusInternalServerError,
+		Code:    ErrCodeInternal,
+		Message: err.Error(),
+	}
+}
+
+// wantsProtobuf reports if the client accepts protobuf responses
+func wantsProtobuf(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+
+		switch mt {
+		case contentTypeProtobuf, "application/protobuf":
+			return true
+		case contentTypeJSON:
+			return false
+		}
+	}
+	return false
+}
+
+// writeAPIResponse writes v as JSON, or m as protobuf if the client asks for it
+func (p *Proxy) writeAPIResponse(w http.ResponseWriter, r *http.Request, status int, v interface{}, m proto.Message) {
+	if !wantsProtobuf(r) {
+		p.writeJSON(w, status, v)
+		return
+	}
+
+	b, err := proto.Marshal(m)
+	if err != nil {
+		p.logger.Errorf("Failed to marshal response: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeProtobuf)
+	w.WriteHeader(status)
+	if _, werr := w.Write(b); werr != nil {
+		p.logger.Errorf("Failed to write response: %v", werr)
+	}
+}
+
+// writeJSON writes v as JSON
+func (p *Proxy) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		p.logger.Errorf("Failed to marshal response: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(status)
+	if _, werr := w.Write(b); werr != nil {
+		p.logger.Errorf("Failed to write response: %v", werr)
+	}
+}
+
+// writeAPIError writes a structured error body
+func (p *Proxy) writeAPIError(w http.ResponseWriter, r *http.Request, aerr *APIError) {
+	m := healthproto.Error{Code: aerr.Code, Message: aerr.Message}
+	for _, f := range aerr.Fields {
+		m.Fields = append(m.Fields, &healthproto.FieldError{Field: f.Field, Message: f.Message})
+	}
+
+	p.writeAPIResponse(w, r, aerr.Status, APIErrorResponse{Error: aerr}, &m)
+}
+
+func serverToProto(s Server) *healthproto.Server {
+	m := &healthproto.Server{
+		Path:   s.Path,
+		Alias:  s.Alias,
+		Port:   int64(s.Port),
+		Scheme: s.Scheme,
+	}
+	for _, rule := range s.Rewrite {
+		m.Rewrite = append(m.Rewrite, &healthproto.RewriteRule{
+			Name:        rule.Name,
+			StripPrefix: rule.StripPrefix,
+			Match:       rule.Match,
+			Replace:     rule.Replace,
+			AddPrefix:   rule.AddPrefix,
+		})
+	}
+	return m
+}
+
+func serverFromProto(m *healthproto.Server) Server {
+	s := Server{
+		Path:   m.GetPath(),
+		Alias:  m.GetAlias(),
+		Port:   int(m.GetPort()),
+		Scheme: m.GetScheme(),
+	}
+	for _, rule := range m.GetRewrite() {
+		s.Rewrite = append(s.Rewrite, RewriteRule{
+			Name:        rule.GetName(),
+			StripPrefix: rule.GetStripPrefix(),
+			Match:       rule.GetMatch(),
+			Replace:     rule.GetReplace(),
+			AddPrefix:   rule.GetAddPrefix(),
+		})
+	}
+	return s
+}
@@ -0,0 +1,296 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/code-server-proxy/healthproto"
+	"github.com/golang/protobuf/proto"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func doAPIRequest(t *testing.T, p *Proxy, method, target string, body interface{}) *httptest.ResponseRecorder {
+	var reader *bytes.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		require.NoError(t, err)
+		reader = bytes.NewReader(b)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req, err := http.NewRequest(method, target, reader)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", contentTypeJSON)
+
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, req)
+	return rr
+}
+
+func decodeAPIError(t *testing.T, rr *httptest.ResponseRecorder) *APIError {
+	resp := APIErrorResponse{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.NotNil(t, resp.Error)
+	return resp.Error
+}
+
+func TestListServers(t *testing.T) {
+	p, err := newTestProxy()
+	require.NoError(t, err)
+
+	rr := doAPIRequest(t, p, "GET", "/api/v1/servers", nil)
+	require.Equal(t, http.StatusOK, rr.Code, "incorrect response code")
+	require.Equal(t, contentTypeJSON, rr.Header().Get("Content-Type"))
+
+	list := ServerList{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &list))
+	require.Len(t, list.Servers, 3)
+	require.Equal(t, "project1", list.Servers[0].Alias)
+}
+
+func TestListServersProtobuf(t *testing.T) {
+	p, err := newTestProxy()
+	require.NoError(t, err)
+
+	req, err := http.NewRequest("GET", "/api/v1/servers", nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept", contentTypeProtobuf)
+
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, "incorrect response code")
+	require.Equal(t, contentTypeProtobuf, rr.Header().Get("Content-Type"))
+
+	list := healthproto.ServerList{}
+	require.NoError(t, proto.Unmarshal(rr.Body.Bytes(), &list))
+	require.Len(t, list.GetServers(), 3)
+	require.Equal(t, int64(9001), list.GetServers()[1].GetPort())
+}
+
+func TestGetServer(t *testing.T) {
+	p, err := newTestProxy()
+	require.NoError(t, err)
+
+	rr := doAPIRequest(t, p, "GET", "/api/v1/servers/project2", nil)
+	require.Equal(t, http.StatusOK, rr.Code, "incorrect response code")
+
+	s := Server{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &s))
+	require.Equal(t, Server{Path: "/a/b/f", Alias: "project2", Port: 9001}, s)
+
+	rr = doAPIRequest(t, p, "GET", "/api/v1/servers/nope", nil)
+	require.Equal(t, http.StatusNotFound, rr.Code, "incorrect response code")
+	require.Equal(t, ErrCodeNotFound, decodeAPIError(t, rr).Code)
+}
+
+func TestCreateServer(t *testing.T) {
+	p, err := newTestProxy()
+	require.NoError(t, err)
+
+	dir, cleanup := newTestFolder(t)
+	defer cleanup()
+
+	s := Server{Path: filepath.Join(dir, "coolproj"), Alias: "coolproj", Port: 1999}
+	rr := doAPIRequest(t, p, "POST", "/api/v1/servers", s)
+	require.Equal(t, http.StatusCreated, rr.Code, "incorrect response code")
+	require.Equal(t, "/api/v1/servers/coolproj", rr.Header().Get("Location"))
+
+	_, ok := p.server("coolproj")
+	require.True(t, ok, "server not registered")
+
+	// Duplicated alias
+	rr = doAPIRequest(t, p, "POST", "/api/v1/servers", Server{Path: filepath.Join(dir, "other"), Alias: "coolproj", Port: 2000})
+	require.Equal(t, http.StatusConflict, rr.Code, "incorrect response code")
+	require.Equal(t, ErrCodeConflict, decodeAPIError(t, rr).Code)
+
+	// Duplicated port
+	rr = doAPIRequest(t, p, "POST", "/api/v1/servers", Server{Path: filepath.Join(dir, "other"), Alias: "other", Port: 1999})
+	require.Equal(t, http.StatusConflict, rr.Code, "incorrect response code")
+	require.Equal(t, ErrCodeConflict, decodeAPIError(t, rr).Code)
+}
+
+func TestCreateServerProtobuf(t *testing.T) {
+	p, err := newTestProxy()
+	require.NoError(t, err)
+
+	dir, cleanup := newTestFolder(t)
+	defer cleanup()
+
+	b, err := proto.Marshal(&healthproto.Server{Path: filepath.Join(dir, "coolproj"), Alias: "coolproj", Port: 1999})
+	require.NoError(t, err)
+
+	req, err := http.NewRequest("POST", "/api/v1/servers", bytes.NewReader(b))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", contentTypeProtobuf)
+	req.Header.Set("Accept", contentTypeProtobuf)
+
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusCreated, rr.Code, "incorrect response code")
+
+	s := healthproto.Server{}
+	require.NoError(t, proto.Unmarshal(rr.Body.Bytes(), &s))
+	require.Equal(t, "coolproj", s.GetAlias())
+}
+
+func TestCreateServerInvalidBody(t *testing.T) {
+	p, err := newTestProxy()
+	require.NoError(t, err)
+
+	req, err := http.NewRequest("POST", "/api/v1/servers", strings.NewReader("{"))
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusBadRequest, rr.Code, "incorrect response code")
+	require.Equal(t, ErrCodeInvalidRequest, decodeAPIError(t, rr).Code)
+
+	req, err = http.NewRequest("POST", "/api/v1/servers", strings.NewReader("path=/a"))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr = httptest.NewRecorder()
+	p.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusUnsupportedMediaType, rr.Code, "incorrect response code")
+	require.Equal(t, ErrCodeUnsupportedMediaType, decodeAPIError(t, rr).Code)
+}
+
+func TestUpdateServer(t *testing.T) {
+	p, err := newTestProxy()
+	require.NoError(t, err)
+
+	dir, cleanup := newTestFolder(t)
+	defer cleanup()
+
+	folder := filepath.Join(dir, "coolproj")
+	rr := doAPIRequest(t, p, "PATCH", "/api/v1/servers/project1", Server{Path: folder, Port: 9100})
+	require.Equal(t, http.StatusOK, rr.Code, "incorrect response code")
+
+	s, ok := p.server("project1")
+	require.True(t, ok)
+	require.Equal(t, Server{Path: folder, Alias: "project1", Port: 9100}, s)
+
+	// Scheme and rewrite rules are patched as well
+	rewrite := []RewriteRule{{Name: "api", StripPrefix: "/api"}}
+	rr = doAPIRequest(t, p, "PATCH", "/api/v1/servers/project1", map[string]interface{}{"scheme": SchemeHTTPS, "rewrite": rewrite})
+	require.Equal(t, http.StatusOK, rr.Code, "incorrect response code")
+
+	s, ok = p.server("project1")
+	require.True(t, ok)
+	require.Equal(t, Server{Path: folder, Alias: "project1", Port: 9100, Scheme: SchemeHTTPS, Rewrite: rewrite}, s)
+
+	rr = doAPIRequest(t, p, "PATCH", "/api/v1/servers/project1", map[string]interface{}{"scheme": "ftp"})
+	require.Equal(t, http.StatusUnprocessableEntity, rr.Code, "incorrect response code")
+
+	rr = doAPIRequest(t, p, "PATCH", "/api/v1/servers/project1", map[string]interface{}{"rewrite": []RewriteRule{}, "schema": "http"})
+	require.Equal(t, http.StatusBadRequest, rr.Code, "unknown fields are refused")
+
+	rr = doAPIRequest(t, p, "PATCH", "/api/v1/servers/project1", map[string]interface{}{"rewrite": []RewriteRule{}})
+	require.Equal(t, http.StatusOK, rr.Code, "incorrect response code")
+
+	s, ok = p.server("project1")
+	require.True(t, ok)
+	require.Equal(t, Server{Path: folder, Alias: "project1", Port: 9100, Scheme: SchemeHTTPS}, s)
+
+	rr = doAPIRequest(t, p, "PATCH", "/api/v1/servers/project1", Server{Scheme: SchemeHTTP})
+	require.Equal(t, http.StatusOK, rr.Code, "incorrect response code")
+
+	rr = doAPIRequest(t, p, "PUT", "/api/v1/servers/project1", Server{Path: folder, Alias: "renamed", Port: 9200})
+	require.Equal(t, http.StatusOK, rr.Code, "incorrect response code")
+
+	_, ok = p.server("project1")
+	require.False(t, ok, "old alias still registered")
+
+	s, ok = p.server("renamed")
+	require.True(t, ok)
+	require.Equal(t, Server{Path: folder, Alias: "renamed", Port: 9200}, s)
+	res, err := p.resolve(&http.Request{RequestURI: "/renamed/index.html"})
+	require.NoError(t, err)
+	require.Equal(t, 9200, res.Server.Port)
+
+	rr = doAPIRequest(t, p, "PUT", "/api/v1/servers/renamed", Server{Path: folder, Alias: "renamed", Port: 9001})
+	require.Equal(t, http.StatusConflict, rr.Code, "incorrect response code")
+}
+
+func TestDeleteServer(t *testing.T) {
+	p, err := newTestProxy()
+	require.NoError(t, err)
+
+	rr := doAPIRequest(t, p, "DELETE", "/api/v1/servers/project3", nil)
+	require.Equal(t, http.StatusNoContent, rr.Code, "incorrect response code")
+
+	_, ok := p.server("project3")
+	require.False(t, ok, "server still registered")
+
+	_, ok = p.routes.Get("/a/d/e")
+	require.False(t, ok, "path still in radix tree")
+
+	rr = doAPIRequest(t, p, "DELETE", "/api/v1/servers/project3", nil)
+	require.Equal(t, http.StatusNotFound, rr.Code, "incorrect response code")
+	require.Equal(t, ErrCodeNotFound, decodeAPIError(t, rr).Code)
+
+	// Legacy route
+	rr = doAPIRequest(t, p, "DELETE", "/remove/project2", nil)
+	require.Equal(t, http.StatusNoContent, rr.Code, "incorrect response code")
+}
+
+func TestRegisterHandlerInvalidBody(t *testing.T) {
+	p, err := newTestProxy()
+	require.NoError(t, err)
+
+	req, err := http.NewRequest("POST", "/register", strings.NewReader("not json"))
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusBadRequest, rr.Code, "incorrect response code")
+	require.Equal(t, ErrCodeInvalidRequest, decodeAPIError(t, rr).Code)
+}
+
+func TestAPIPersistsLatestConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "code-server-proxy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := filepath.Join(dir, "config.yaml")
+	p, err := NewProxy(
+		UseLogger(logrus.New()),
+		UseCode(Code{Servers: []Server{{Path: dir, Alias: "project1", Port: 8000}}}),
+		UseConfig(config),
+	)
+	require.NoError(t, err)
+
+	// Quick changes end up with the latest registry in the config
+	for port := 8001; port <= 8050; port++ {
+		rr := doAPIRequest(t, p, "PATCH", "/api/v1/servers/project1", map[string]interface{}{"port": port})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		code, err := LoadConfig(config)
+		if err == nil && len(code.Servers) == 1 && code.Servers[0].Port == 8050 {
+			break
+		}
+		require.True(t, time.Now().Before(deadline), "config was not written")
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The latest config was renamed over the config, no temporary files are
+	// left next to it
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	require.Equal(t, "config.yaml", files[0].Name())
+}
@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"fmt"
+	"html"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// DefaultBodyContentTypes are the content types rewritten if none are
+// configured. JavaScript is only rewritten on demand, since root-relative
+// string literals are not always URLs.
+var DefaultBodyContentTypes = []string{"text/html", "text/css"}
+
+// BodyRewrite configures the rewriting of response bodies, so that
+// code-servers work under the project prefix without Referer routing
+type BodyRewrite struct {
+	Enabled bool `yaml:"enabled,omitempty"`
+	// ContentTypes are rewritten, DefaultBodyContentTypes if empty
+	ContentTypes []string `yaml:"contentTypes,omitempty"`
+}
+
+// rewrites reports whether bodies of contentType are rewritten
+func (b BodyRewrite) rewrites(contentType string) bool {
+	if !b.Enabled {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	types := b.ContentTypes
+	if len(types) == 0 {
+		types = DefaultBodyContentTypes
+	}
+	for _, t := range types {
+		if strings.EqualFold(t, mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	headPattern    = regexp.MustCompile(`(?i)<head(\s[^>]*)?>`)
+	basePattern    = regexp.MustCompile(`(?i)<base\s`)
+	attrPattern    = regexp.MustCompile(`(?i)(\s(?:href|src|action|poster|formaction)\s*=\s*["'])(/[^"']*)`)
+	cssURLPattern  = regexp.MustCompile(`(url\(\s*["']?)(/[^"')\s]*)`)
+	jsPathPattern  = regexp.MustCompile(`(["'` + "`" + `])(/[A-Za-z0-9_~.\-][^"'` + "`" + `\s]*)`)
+	javascriptType = regexp.MustCompile(`(?i)javascript`)
+)
+
+// hasBody reports whether resp to r carries a body. Responses to HEAD and
+// 1xx, 204 and 304 responses have none, their Content-Length describes the
+// body of a GET.
+func hasBody(r *http.Request, resp *http.Response) bool {
+	if r.Method == http.MethodHead {
+		return false
+	}
+	switch {
+	case resp.StatusCode >= 100 && resp.StatusCode < 200:
+		return false
+	case resp.StatusCode == http.StatusNoContent, resp.StatusCode == http.StatusNotModified:
+		return false
+	}
+	return true
+}
+
+// rewriteBody adds prefix to the root-relative URLs of a body of contentType.
+// dir is the directory of the page under the project, relative URLs of HTML
+// pages resolve against it.
+func rewriteBody(body []byte, contentType, prefix, dir string) []byte {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	switch {
+	case mediaType == "text/html":
+		return rewriteHTML(body, prefix, dir)
+	case mediaType == "text/css":
+		return rewriteCSS(body, prefix)
+	case javascriptType.MatchString(mediaType):
+		return rewriteJS(body, prefix)
+	}
+	return body
+}
+
+// rewriteHTML adds prefix to root-relative URLs of attributes and inline
+// styles, and injects a base element of dir under prefix unless the page
+// has one
+func rewriteHTML(body []byte, prefix, dir string) []byte {
+	body = rewriteURLs(attrPattern, body, prefix)
+	body = rewriteCSS(body, prefix)
+
+	if basePattern.Match(body) {
+		return body
+	}
+
+	loc := headPattern.FindIndex(body)
+	if loc == nil {
+		return body
+	}
+
+	base := fmt.Sprintf(`<base href="%s">`, html.EscapeString(prefix+dir))
+	rewritten := make([]byte, 0, len(body)+len(base))
+	rewritten = append(rewritten, body[:loc[1]]...)
+	rewritten = append(rewritten, base...)
+	return append(rewritten, body[loc[1]:]...)
+}
+
+// pageDir returns the directory of the page requested by r under the
+// project of res, e.g. /a/ of /project1/a/page.html
+func pageDir(r *http.Request, res Resolution) string {
+	p := trimPathPrefix(requestPath(r), res.StripPrefix)
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	return p[:strings.LastIndex(p, "/")+1]
+}
+
+// rewriteCSS adds prefix to root-relative URLs of url()
+func rewriteCSS(body []byte, prefix string) []byte {
+	return rewriteURLs(cssURLPattern, body, prefix)
+}
+
+// rewriteJS adds prefix to string literals holding root-relative paths
+func rewriteJS(body []byte, prefix string) []byte {
+	return rewriteURLs(jsPathPattern, body, prefix)
+}
+
+// rewriteURLs adds prefix to the URLs matched by the second group of
+// pattern, the first group is kept as is
+func rewriteURLs(pattern *regexp.Regexp, body []byte, prefix string) []byte {
+	return pattern.ReplaceAllFunc(body, func(m []byte) []byte {
+		groups := pattern.FindSubmatch(m)
+		return append(append([]byte{}, groups[1]...), prefixPath(string(groups[2]), prefix)...)
+	})
+}
+
+// prefixPath adds prefix to the root-relative path p, unless it is
+// protocol-relative or has the prefix already
+func prefixPath(p, prefix string) string {
+	if strings.HasPrefix(p, "//") || p == prefix || strings.HasPrefix(p, prefix+"/") {
+		return p
+	}
+	return prefix + p
+}
@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRewriteHTML(t *testing.T) {
+	html := `<html><head lang="en"><link href="/static/main.css"></head>` +
+		`<body style="background: url('/img/bg.png')"><script src='/static/main.js'></script>` +
+		`<a href="/project1/x">x</a><a href="//cdn.example.com/x">cdn</a><a href="rel">rel</a>` +
+		`<form action="/login"></form></body></html>`
+
+	expected := `<html><head lang="en"><base href="/project1/"><link href="/project1/static/main.css"></head>` +
+		`<body style="background: url('/project1/img/bg.png')"><script src='/project1/static/main.js'></script>` +
+		`<a href="/project1/x">x</a><a href="//cdn.example.com/x">cdn</a><a href="rel">rel</a>` +
+		`<form action="/project1/login"></form></body></html>`
+
+	require.Equal(t, expected, string(rewriteBody([]byte(html), "text/html; charset=utf-8", "/project1", "/")))
+
+	// Existing base elements are rewritten instead
+	html = `<head><base href="/"></head>`
+	require.Equal(t, `<head><base href="/project1/"></head>`, string(rewriteBody([]byte(html), "text/html", "/project1", "/a/")))
+	html = `<head><base href="static/"></head>`
+	require.Equal(t, html, string(rewriteBody([]byte(html), "text/html", "/project1", "/a/")))
+
+	// Relative URLs of nested pages resolve against their directory
+	html = `<head></head><a href="rel">rel</a>`
+	require.Equal(t, `<head><base href="/project1/a/b/"></head><a href="rel">rel</a>`,
+		string(rewriteBody([]byte(html), "text/html", "/project1", "/a/b/")))
+}
+
+func TestPageDir(t *testing.T) {
+	data := []struct {
+		uri   string
+		strip string
+		dir   string
+	}{
+		{"/project1/a/b/page.html", "/project1", "/a/b/"},
+		{"/project1/a/b/", "/project1", "/a/b/"},
+		{"/project1", "/project1", "/"},
+		{"/a/page.html?x=/y", "", "/a/"},
+		{"/", "", "/"},
+	}
+
+	for _, d := range data {
+		require.Equal(t, d.dir, pageDir(&http.Request{RequestURI: d.uri}, Resolution{StripPrefix: d.strip}), d.uri)
+	}
+}
+
+func TestRewriteCSSAndJS(t *testing.T) {
+	css := `a { background: url(/img/a.png) } b { background: url("/img/b.png") } c { background: url(img/c.png) }`
+	expected := `a { background: url(/project1/img/a.png) } b { background: url("/project1/img/b.png") } c { background: url(img/c.png) }`
+	require.Equal(t, expected, string(rewriteBody([]byte(css), "text/css", "/project1", "/")))
+
+	js := "fetch('/api/x'); load(\"/static/y.js\"); var re = a / b / c; var s = '/'; var t = `/tpl`;"
+	expected = "fetch('/project1/api/x'); load(\"/project1/static/y.js\"); var re = a / b / c; var s = '/'; var t = `/project1/tpl`;"
+	require.Equal(t, expected, string(rewriteBody([]byte(js), "application/javascript", "/project1", "/")))
+}
+
+func TestBodyRewriteContentTypes(t *testing.T) {
+	b := BodyRewrite{Enabled: true}
+	require.True(t, b.rewrites("text/html; charset=utf-8"))
+	require.True(t, b.rewrites("text/css"))
+	require.False(t, b.rewrites("application/javascript"))
+	require.False(t, b.rewrites("invalid;;"))
+
+	b.ContentTypes = []string{"application/javascript"}
+	require.True(t, b.rewrites("application/javascript"))
+	require.False(t, b.rewrites("text/html"))
+
+	require.False(t, BodyRewrite{}.rewrites("text/html"))
+}
+
+func TestForwardRewriteBody(t *testing.T) {
+	port, closeBackend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<head></head><img src="/x.png" alt="%s">`, r.Header.Get("X-Forwarded-Prefix"))
+	})
+	defer closeBackend()
+
+	p, err := NewProxy(
+		UseCode(Code{
+			Servers: []Server{{Path: "/a/b/c", Alias: "project1", Port: port}},
+			Routing: Routing{Body: BodyRewrite{Enabled: true}},
+		}),
+		UseLogger(logrus.New()),
+	)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("GET", "/project1/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	body, err := ioutil.ReadAll(rr.Result().Body)
+	require.NoError(t, err)
+	require.Equal(t, `<head><base href="/project1/"></head><img src="/project1/x.png" alt="/project1">`, string(body))
+	require.Equal(t, fmt.Sprint(len(body)), rr.Header().Get("Content-Length"))
+
+	// Incoming headers are not modified
+	require.Equal(t, "gzip", req.Header.Get("Accept-Encoding"))
+}
+
+func TestForwardRewriteBodyless(t *testing.T) {
+	port, closeBackend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Content-Length", "100")
+		if r.Header.Get("If-None-Match") != "" {
+			w.WriteHeader(http.StatusNotModified)
+		}
+	})
+	defer closeBackend()
+
+	p, err := NewProxy(
+		UseCode(Code{
+			Servers: []Server{{Path: "/a/b/c", Alias: "project1", Port: port}},
+			Routing: Routing{Body: BodyRewrite{Enabled: true}},
+		}),
+		UseLogger(logrus.New()),
+	)
+	require.NoError(t, err)
+
+	// Bodyless responses keep the Content-Length of the code-server
+	req := httptest.NewRequest("HEAD", "/project1/", nil)
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "100", rr.Header().Get("Content-Length"))
+
+	req = httptest.NewRequest("GET", "/project1/", nil)
+	req.Header.Set("If-None-Match", `"x"`)
+	rr = httptest.NewRecorder()
+	p.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusNotModified, rr.Code)
+	require.Empty(t, rr.Header().Get("Content-Length"))
+	require.Empty(t, rr.Body.String())
+}
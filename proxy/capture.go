@@ -0,0 +1,194 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultCaptureFile is the file frames are captured to if none is configured
+const DefaultCaptureFile = "websocket-capture.jsonl"
+
+// Directions of captured frames
+const (
+	DirectionToBackend  = "toBackend"
+	DirectionToFrontend = "toFrontend"
+)
+
+// Types of captured frames
+const (
+	FrameText   = "text"
+	FrameBinary = "binary"
+	FrameClose  = "close"
+)
+
+// Capture configures the recording of websocket frames for debugging
+type Capture struct {
+	// Servers are the aliases of the projects whose sessions are captured
+	Servers []string `yaml:"servers,omitempty"`
+	// File is the JSON lines file frames are appended to, DefaultCaptureFile if empty
+	File string `yaml:"file,omitempty"`
+	// Payload records the content of messages, not only their size
+	Payload bool `yaml:"payload,omitempty"`
+	// MaxSize is the size in bytes a file is rotated at, DefaultMaxFileSize if zero
+	MaxSize int64 `yaml:"maxSize,omitempty"`
+	// MaxFiles is the number of rotated files kept, DefaultMaxFiles if zero
+	MaxFiles int `yaml:"maxFiles,omitempty"`
+}
+
+// Frame is a captured websocket message or close frame
+type Frame struct {
+	Time      time.Time `json:"time"`
+	Session   string    `json:"session"`
+	Server    string    `json:"server"`
+	Direction string    `json:"direction"`
+	Type      string    `json:"type"`
+	Size      int64     `json:"size"`
+	// Payload is only recorded if configured
+	Payload []byte `json:"payload,omitempty"`
+	// Code and Text are the close code and reason of close frames
+	Code int    `json:"code,omitempty"`
+	Text string `json:"text,omitempty"`
+}
+
+// capturer writes the frames of captured projects
+type capturer struct {
+	servers map[string]bool
+	payload bool
+	out     *rotatingWriter
+	logger  *logrus.Logger
+
+	// mu keeps the frames of concurrent sessions on separate lines
+	mu sync.Mutex
+}
+
+// newCapturer opens the capture file, it returns nil if no project is
+// captured
+func newCapturer(c Capture, logger *logrus.Logger) (*capturer, error) {
+	if len(c.Servers) == 0 {
+		return nil, nil
+	}
+
+	file := c.File
+	if file == "" {
+		file = DefaultCaptureFile
+	}
+	out, err := openRotatingWriter(file, c.MaxSize, c.MaxFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	servers := map[string]bool{}
+	for _, alias := range c.Servers {
+		servers[alias] = true
+	}
+	return &capturer{servers: servers, payload: c.Payload, out: out, logger: logger}, nil
+}
+
+// captures reports whether sessions of alias are captured
+func (c *capturer) captures(alias string) bool {
+	return c != nil && c.servers[alias]
+}
+
+// record appends f to the capture file
+func (c *capturer) record(f Frame) {
+	if !c.payload {
+		f.Payload = nil
+	}
+
+	b, err := json.Marshal(f)
+	if err != nil {
+		c.logger.Errorf("Failed to marshal captured frame: %v", err)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.out.Write(append(b, '\n')); err != nil {
+		c.logger.Errorf("Failed to capture frame: %v", err)
+	}
+}
+
+// close closes the capture file
+func (c *capturer) close() error {
+	if c == nil {
+		return nil
+	}
+	return c.out.Close()
+}
+
+// frameType returns the captured type of the message type mt
+func frameType(mt int) string {
+	switch mt {
+	case websocket.TextMessage:
+		return FrameText
+	case websocket.BinaryMessage:
+		return FrameBinary
+	case websocket.CloseMessage:
+		return FrameClose
+	}
+	return ""
+}
+
+// messageType returns the message type of the captured type t
+func messageType(t string) (int, error) {
+	switch t {
+	case FrameText:
+		return websocket.TextMessage, nil
+	case FrameBinary:
+		return websocket.BinaryMessage, nil
+	case FrameClose:
+		return websocket.CloseMessage, nil
+	}
+	return 0, errors.New("Unknown frame type: " + t)
+}
+
+// direction returns the direction of messages sent to dst
+func direction(dst leg) string {
+	if dst.side == sideBackend {
+		return DirectionToBackend
+	}
+	return DirectionToFrontend
+}
+
+// capturesPayload reports whether the messages of the session are captured
+// with their payload
+func (s *session) capturesPayload() bool {
+	return s.capturer.captures(s.info.Server) && s.capturer.payload
+}
+
+// capture records a message of size sent to dst if the session is captured
+func (s *session) capture(dst leg, mt int, payload []byte, size int64) {
+	if !s.capturer.captures(s.info.Server) {
+		return
+	}
+	s.capturer.record(Frame{
+		Time:      time.Now(),
+		Session:   s.id,
+		Server:    s.info.Server,
+		Direction: direction(dst),
+		Type:      frameType(mt),
+		Size:      size,
+		Payload:   payload,
+	})
+}
+
+// captureClose records a close frame forwarded to dst
+func (s *session) captureClose(dst leg, ce *websocket.CloseError) {
+	if !s.capturer.captures(s.info.Server) {
+		return
+	}
+	s.capturer.record(Frame{
+		Time:      time.Now(),
+		Session:   s.id,
+		Server:    s.info.Server,
+		Direction: direction(dst),
+		Type:      FrameClose,
+		Code:      ce.Code,
+		Text:      ce.Text,
+	})
+}
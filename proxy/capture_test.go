@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+// waitSessions waits until p has no websocket sessions left
+func waitSessions(t *testing.T, p *Proxy) {
+	deadline := time.Now().Add(5 * time.Second)
+	for len(p.sessions.list("")) > 0 {
+		require.True(t, time.Now().Before(deadline), "session did not end")
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// captureSession runs a session through a proxy capturing project1 and
+// returns the captured frames
+func captureSession(t *testing.T, c Capture) []Frame {
+	p, wsURL, cleanup := newTestWebsocketProxy(t, echo(websocket.Upgrader{CheckOrigin: anyOrigin}, nil), useWebsocket(Websocket{Capture: c}))
+	defer cleanup()
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"/project1/", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	for _, msg := range []struct {
+		mt      int
+		payload string
+	}{{websocket.TextMessage, "hello"}, {websocket.BinaryMessage, "\x00\x01\x02"}} {
+		require.NoError(t, conn.WriteMessage(msg.mt, []byte(msg.payload)))
+		_, _, err = conn.ReadMessage()
+		require.NoError(t, err)
+	}
+	require.NoError(t, conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "bye")))
+
+	waitSessions(t, p)
+	require.NoError(t, p.Close())
+
+	f, err := os.Open(c.File)
+	require.NoError(t, err)
+	defer f.Close()
+
+	frames, err := ReadCapture(f, "")
+	require.NoError(t, err)
+	return frames
+}
+
+func TestCapture(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "capture.jsonl")
+	frames := captureSession(t, Capture{Servers: []string{"project1"}, File: file, Payload: true})
+	require.Len(t, frames, 5)
+
+	expected := []Frame{
+		{Direction: DirectionToBackend, Type: FrameText, Size: 5, Payload: []byte("hello")},
+		{Direction: DirectionToFrontend, Type: FrameText, Size: 5, Payload: []byte("hello")},
+		{Direction: DirectionToBackend, Type: FrameBinary, Size: 3, Payload: []byte{0, 1, 2}},
+		{Direction: DirectionToFrontend, Type: FrameBinary, Size: 3, Payload: []byte{0, 1, 2}},
+		{Direction: DirectionToBackend, Type: FrameClose, Code: websocket.CloseNormalClosure, Text: "bye"},
+	}
+	for i, f := range frames {
+		require.NotEmpty(t, f.Session)
+		require.Equal(t, frames[0].Session, f.Session)
+		require.Equal(t, "project1", f.Server)
+		require.False(t, f.Time.IsZero())
+
+		f.Session, f.Server, f.Time = "", "", time.Time{}
+		require.Equal(t, expected[i], f)
+	}
+
+	// The capture reproduces the session against a fake code-server
+	errs := make(chan error, 1)
+	_, wsURL, cleanup := newTestWebsocketProxy(t, ReplayHandler(frames, errs))
+	defer cleanup()
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"/project1/", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, ReplayFrontend(conn, frames))
+	require.NoError(t, <-errs)
+}
+
+func TestCaptureWithoutPayload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "capture.jsonl")
+	frames := captureSession(t, Capture{Servers: []string{"project1"}, File: file})
+	require.Len(t, frames, 5)
+	for _, f := range frames {
+		require.Nil(t, f.Payload)
+	}
+	require.Equal(t, int64(5), frames[0].Size)
+}
+
+func TestCaptureOtherProject(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "capture.jsonl")
+	require.Empty(t, captureSession(t, Capture{Servers: []string{"project2"}, File: file}))
+}
+
+func TestReplayMismatch(t *testing.T) {
+	frames := []Frame{
+		{Direction: DirectionToBackend, Type: FrameText, Size: 5, Payload: []byte("hello")},
+	}
+
+	errs := make(chan error, 1)
+	_, wsURL, cleanup := newTestWebsocketProxy(t, ReplayHandler(frames, errs))
+	defer cleanup()
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"/project1/", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("world")))
+	require.EqualError(t, <-errs, "Frame 0: payload differs")
+}
+
+func TestReadCaptureSession(t *testing.T) {
+	capture := `{"session":"a","direction":"toBackend","type":"text","size":1}
+
+{"session":"b","direction":"toBackend","type":"text","size":2}
+`
+	frames, err := ReadCapture(strings.NewReader(capture), "b")
+	require.NoError(t, err)
+	require.Len(t, frames, 1)
+	require.Equal(t, int64(2), frames[0].Size)
+
+	_, err = ReadCapture(strings.NewReader("{"), "")
+	require.Error(t, err)
+}
@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"bufio"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+// DefaultCompressionLevel is the flate level of compressed messages
+const DefaultCompressionLevel = flate.BestSpeed
+
+// Compression configures permessage-deflate on the legs of websocket
+// sessions. Compression is negotiated, so it only applies if the other
+// side supports it as well.
+type Compression struct {
+	// Frontend enables compression between browsers and the proxy
+	Frontend bool `yaml:"frontend,omitempty"`
+	// Backend enables compression between the proxy and code-servers
+	Backend bool `yaml:"backend,omitempty"`
+	// Level is the flate level from -2 to 9, DefaultCompressionLevel if zero
+	Level int `yaml:"level,omitempty"`
+	// Threshold is the size below which messages are sent uncompressed
+	Threshold int `yaml:"threshold,omitempty"`
+}
+
+// level returns the compression level of c
+func (c Compression) level() int {
+	if c.Level == 0 {
+		return DefaultCompressionLevel
+	}
+	return c.Level
+}
+
+// validateCompression checks the compression level
+func validateCompression(c Compression) error {
+	if c.Level < flate.HuffmanOnly || c.Level > flate.BestCompression {
+		return fmt.Errorf("Compression level must be between %d and %d", flate.HuffmanOnly, flate.BestCompression)
+	}
+	if c.Threshold < 0 {
+		return errors.New("Compression threshold must not be negative")
+	}
+	return nil
+}
+
+// LegStats counts the bytes of messages of a websocket leg and the bytes
+// on the wire, including framing and handshakes
+type LegStats struct {
+	PayloadBytes int64 `json:"payloadBytes"`
+	WireBytes    int64 `json:"wireBytes"`
+	// SavedBytes is the difference, negative if framing outweighs compression
+	SavedBytes int64 `json:"savedBytes"`
+}
+
+// WebsocketStats is the response of GET /debug/websocket
+type WebsocketStats struct {
+	Frontend   LegStats        `json:"frontend"`
+	Backend    LegStats        `json:"backend"`
+	Violations LimitViolations `json:"violations"`
+}
+
+// legCounter counts the bytes of a leg of all websocket sessions
+type legCounter struct {
+	payload int64
+	wire    int64
+}
+
+func (c *legCounter) stats() LegStats {
+	payload := atomic.LoadInt64(&c.payload)
+	wire := atomic.LoadInt64(&c.wire)
+	return LegStats{PayloadBytes: payload, WireBytes: wire, SavedBytes: payload - wire}
+}
+
+// websocketCounters counts the bytes of websocket sessions by leg
+type websocketCounters struct {
+	frontend   legCounter
+	backend    legCounter
+	violations violationCounters
+}
+
+// countingConn counts the bytes read and written on the wire
+type countingConn struct {
+	net.Conn
+	counter *legCounter
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&c.counter.wire, int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(&c.counter.wire, int64(n))
+	return n, err
+}
+
+// countingResponseWriter hands a countingConn to the websocket upgrader
+type countingResponseWriter struct {
+	http.ResponseWriter
+	counter *legCounter
+}
+
+func (w countingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("Response writer can not be hijacked")
+	}
+
+	conn, brw, err := h.Hijack()
+	if err != nil || brw.Reader.Buffered() > 0 {
+		return conn, brw, err
+	}
+
+	cc := &countingConn{Conn: conn, counter: w.counter}
+	return cc, bufio.NewReadWriter(bufio.NewReader(cc), bufio.NewWriter(cc)), nil
+}
+
+// countingDial dials code-servers with a countingConn
+func (p *Proxy) countingDial(network, addr string) (net.Conn, error) {
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{Conn: conn, counter: &p.counters.backend}, nil
+}
+
+// debugWebsocketHandler shows the bytes of websocket sessions by leg
+func (p *Proxy) debugWebsocketHandler(w http.ResponseWriter, r *http.Request) {
+	p.writeJSON(w, http.StatusOK, WebsocketStats{
+		Frontend:   p.counters.frontend.stats(),
+		Backend:    p.counters.backend.stats(),
+		Violations: p.counters.violations.violations(),
+	})
+}
@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+// roundTrip sends a compressible message through p and returns the
+// websocket stats afterwards
+func roundTrip(t *testing.T, p *Proxy, wsURL string, enableCompression bool) (*http.Response, WebsocketStats) {
+	dialer := *websocket.DefaultDialer
+	dialer.EnableCompression = enableCompression
+
+	conn, resp, err := dialer.Dial(wsURL+"/project1/", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	msg := strings.Repeat("code-server ", 4096)
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(msg)))
+	_, echoed, err := conn.ReadMessage()
+	require.NoError(t, err)
+	require.Equal(t, msg, string(echoed))
+
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "/debug/websocket", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var stats WebsocketStats
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	require.Equal(t, int64(2*len(msg)), stats.Frontend.PayloadBytes)
+	require.Equal(t, int64(2*len(msg)), stats.Backend.PayloadBytes)
+	return resp, stats
+}
+
+func TestWebsocketCompression(t *testing.T) {
+	backend := echo(websocket.Upgrader{CheckOrigin: anyOrigin, EnableCompression: true}, nil)
+	p, wsURL, cleanup := newTestWebsocketProxy(t, backend, useWebsocket(Websocket{
+		Compression: Compression{Frontend: true, Backend: true},
+	}))
+	defer cleanup()
+
+	resp, stats := roundTrip(t, p, wsURL, true)
+	require.Contains(t, resp.Header.Get("Sec-Websocket-Extensions"), "permessage-deflate")
+	require.True(t, stats.Frontend.SavedBytes > 0, "frontend saved %d bytes", stats.Frontend.SavedBytes)
+	require.True(t, stats.Backend.SavedBytes > 0, "backend saved %d bytes", stats.Backend.SavedBytes)
+}
+
+func TestWebsocketCompressionDisabled(t *testing.T) {
+	backend := echo(websocket.Upgrader{CheckOrigin: anyOrigin, EnableCompression: true}, nil)
+	p, wsURL, cleanup := newTestWebsocketProxy(t, backend)
+	defer cleanup()
+
+	resp, stats := roundTrip(t, p, wsURL, true)
+	require.Empty(t, resp.Header.Get("Sec-Websocket-Extensions"))
+	require.True(t, stats.Frontend.SavedBytes < 0)
+	require.True(t, stats.Backend.SavedBytes < 0)
+}
+
+func TestWebsocketCompressionFrontendOnly(t *testing.T) {
+	backend := echo(websocket.Upgrader{CheckOrigin: anyOrigin, EnableCompression: true}, nil)
+	p, wsURL, cleanup := newTestWebsocketProxy(t, backend, useWebsocket(Websocket{
+		Compression: Compression{Frontend: true, Level: 9},
+	}))
+	defer cleanup()
+
+	_, stats := roundTrip(t, p, wsURL, true)
+	require.True(t, stats.Frontend.SavedBytes > 0)
+	require.True(t, stats.Backend.SavedBytes < 0)
+}
+
+func TestWebsocketCompressionThreshold(t *testing.T) {
+	backend := echo(websocket.Upgrader{CheckOrigin: anyOrigin}, nil)
+	p, wsURL, cleanup := newTestWebsocketProxy(t, backend, useWebsocket(Websocket{
+		Compression: Compression{Frontend: true, Threshold: 1 << 20},
+	}))
+	defer cleanup()
+
+	// The client compresses its message, the proxy doesn't
+	resp, stats := roundTrip(t, p, wsURL, true)
+	require.Contains(t, resp.Header.Get("Sec-Websocket-Extensions"), "permessage-deflate")
+	require.True(t, stats.Frontend.WireBytes > stats.Frontend.PayloadBytes/2)
+}
+
+func TestValidateCompression(t *testing.T) {
+	for _, c := range []Compression{{Level: 10}, {Level: -3}, {Threshold: -1}} {
+		_, err := NewProxy(
+			UseLogger(logrus.New()),
+			UseCode(Code{Websocket: Websocket{Compression: c}}),
+		)
+		require.Error(t, err, "%+v", c)
+	}
+}
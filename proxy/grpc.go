@@ -0,0 +1,216 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	"github.com/code-server-proxy/healthproto"
+)
+
+// DefaultProbeInterval is how often the gRPC server probes code-servers
+const DefaultProbeInterval = 10 * time.Second
+
+// GRPCServer serves the management and health services of a proxy over gRPC
+type GRPCServer struct {
+	proxy    *Proxy
+	server   *grpc.Server
+	health   *health.Server
+	interval time.Duration
+	done     chan struct{}
+	stopOnce sync.Once
+
+	// mu guards probed, the aliases of the last probe, checked, its health
+	// check, and changed, which is closed when checked changes
+	mu      sync.Mutex
+	probed  map[string]bool
+	checked *healthproto.HealthCheck
+	changed chan struct{}
+}
+
+// NewGRPCServer creates a gRPC server which manages p. Code-servers are
+// probed every interval and their state is published with the standard
+// gRPC health checking protocol, using aliases as service names.
+func NewGRPCServer(p *Proxy, interval time.Duration, opts ...grpc.ServerOption) *GRPCServer {
+	if interval <= 0 {
+		interval = DefaultProbeInterval
+	}
+
+	g := &GRPCServer{
+		proxy:    p,
+		server:   grpc.NewServer(opts...),
+		health:   health.NewServer(),
+		interval: interval,
+		done:     make(chan struct{}),
+		changed:  make(chan struct{}),
+	}
+
+	healthproto.RegisterCodeServerProxyServer(g.server, g)
+	healthpb.RegisterHealthServer(g.server, g.health)
+
+	return g
+}
+
+// Serve probes code-servers and accepts gRPC connections on l until Stop is called
+func (g *GRPCServer) Serve(l net.Listener) error {
+	g.probe()
+	go func() {
+		ticker := time.NewTicker(g.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				g.probe()
+			case <-g.done:
+				return
+			}
+		}
+	}()
+
+	return g.server.Serve(l)
+}
+
+// Stop stops probing code-servers and closes all gRPC connections. It may
+// be called more than once.
+func (g *GRPCServer) Stop() {
+	g.stopProbing()
+	g.server.Stop()
+}
+
+// GracefulStop stops probing code-servers, ends WatchHealth streams and
+// waits for other pending RPCs. Stop interrupts it.
+func (g *GRPCServer) GracefulStop() {
+	g.stopProbing()
+	g.server.GracefulStop()
+}
+
+// stopProbing stops probing and reports all services as not serving
+func (g *GRPCServer) stopProbing() {
+	g.stopOnce.Do(func() {
+		close(g.done)
+		g.health.Shutdown()
+	})
+}
+
+// probe updates the serving status of every code-server and notifies
+// WatchHealth streams if the health check changed. Code-servers removed or
+// renamed since the last probe become unknown.
+func (g *GRPCServer) probe() {
+	g.health.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	checked := g.proxy.healthCheck("")
+	if g.checked == nil || !proto.Equal(g.checked, checked) {
+		g.checked = checked
+		close(g.changed)
+		g.changed = make(chan struct{})
+	}
+
+	probed := map[string]bool{}
+	for _, status := range checked.GetCodeServers() {
+		servingStatus := healthpb.HealthCheckResponse_NOT_SERVING
+		if status.GetState() == "OK" {
+			servingStatus = healthpb.HealthCheckResponse_SERVING
+		}
+		g.health.SetServingStatus(status.GetAlias(), servingStatus)
+		probed[status.GetAlias()] = true
+	}
+
+	for alias := range g.probed {
+		if !probed[alias] {
+			g.health.SetServingStatus(alias, healthpb.HealthCheckResponse_SERVICE_UNKNOWN)
+		}
+	}
+	g.probed = probed
+}
+
+// ListServers lists registered code-servers
+func (g *GRPCServer) ListServers(ctx context.Context, req *healthproto.ListServersRequest) (*healthproto.ServerList, error) {
+	list := healthproto.ServerList{}
+	for _, s := range g.proxy.servers() {
+		list.Servers = append(list.Servers, serverToProto(s))
+	}
+	return &list, nil
+}
+
+// GetServer gets the code-server registered under an alias
+func (g *GRPCServer) GetServer(ctx context.Context, req *healthproto.GetServerRequest) (*healthproto.Server, error) {
+	s, ok := g.proxy.server(req.GetAlias())
+	if !ok {
+		return nil, grpcError(ErrServerNotFound, Server{Alias: req.GetAlias()})
+	}
+	return serverToProto(s), nil
+}
+
+// Register registers a code-server
+func (g *GRPCServer) Register(ctx context.Context, req *healthproto.Server) (*healthproto.Server, error) {
+	s := serverFromProto(req)
+	if err := g.proxy.addServer(s); err != nil {
+		return nil, grpcError(err, s)
+	}
+	return serverToProto(s), nil
+}
+
+// Remove unregisters the code-server registered under an alias
+func (g *GRPCServer) Remove(ctx context.Context, req *healthproto.RemoveRequest) (*healthproto.RemoveResponse, error) {
+	if err := g.proxy.removeServer(req.GetAlias()); err != nil {
+		return nil, grpcError(err, Server{Alias: req.GetAlias()})
+	}
+
+	g.health.SetServingStatus(req.GetAlias(), healthpb.HealthCheckResponse_SERVICE_UNKNOWN)
+	return &healthproto.RemoveResponse{}, nil
+}
+
+// WatchHealth streams the health of code-servers whenever a probe of the
+// server finds it changed. Streams don't probe code-servers themselves.
+func (g *GRPCServer) WatchHealth(req *healthproto.WatchHealthRequest, stream healthproto.CodeServerProxy_WatchHealthServer) error {
+	var last *healthproto.HealthCheck
+	for {
+		g.mu.Lock()
+		checked, changed := g.checked, g.changed
+		g.mu.Unlock()
+
+		if checked != nil && checked != last {
+			if err := stream.Send(checked); err != nil {
+				return err
+			}
+			last = checked
+		}
+
+		select {
+		case <-changed:
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-g.done:
+			return status.Error(codes.Unavailable, "server is stopping")
+		}
+	}
+}
+
+// grpcError converts an error of the server registry about s to a gRPC status
+func grpcError(err error, s Server) error {
+	aerr := registryError(err, s)
+
+	code := codes.Internal
+	switch aerr.Code {
+	case ErrCodeNotFound:
+		code = codes.NotFound
+	case ErrCodeConflict:
+		code = codes.AlreadyExists
+	case ErrCodeInvalidRequest, ErrCodeValidationFailed:
+		code = codes.InvalidArgument
+	}
+
+	return status.Error(code, aerr.Message)
+}
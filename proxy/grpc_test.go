@@ -0,0 +1,235 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/code-server-proxy/healthproto"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestGRPCClient(t *testing.T, p *Proxy) (*grpc.ClientConn, func()) {
+	l := bufconn.Listen(1024 * 1024)
+	g := NewGRPCServer(p, time.Hour)
+	go g.Serve(l)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithDialer(func(string, time.Duration) (net.Conn, error) {
+			return l.Dial()
+		}),
+		grpc.WithInsecure(),
+	)
+	require.NoError(t, err)
+
+	return conn, func() {
+		conn.Close()
+		g.Stop()
+	}
+}
+
+func TestGRPCServers(t *testing.T) {
+	p, err := newTestProxy()
+	require.NoError(t, err)
+
+	conn, cleanup := newTestGRPCClient(t, p)
+	defer cleanup()
+
+	dir, rmdir := newTestFolder(t)
+	defer rmdir()
+
+	client := healthproto.NewCodeServerProxyClient(conn)
+	ctx := context.Background()
+
+	list, err := client.ListServers(ctx, &healthproto.ListServersRequest{})
+	require.NoError(t, err)
+	require.Len(t, list.GetServers(), 3)
+
+	s, err := client.GetServer(ctx, &healthproto.GetServerRequest{Alias: "project2"})
+	require.NoError(t, err)
+	require.Equal(t, "/a/b/f", s.GetPath())
+
+	_, err = client.GetServer(ctx, &healthproto.GetServerRequest{Alias: "nope"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	folder := filepath.Join(dir, "coolproj")
+	s, err = client.Register(ctx, &healthproto.Server{Path: folder, Alias: "coolproj", Port: 1999})
+	require.NoError(t, err)
+	require.Equal(t, "coolproj", s.GetAlias())
+
+	_, err = client.Register(ctx, &healthproto.Server{Path: folder, Alias: "status", Port: 2000})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.Register(ctx, &healthproto.Server{Path: filepath.Join(dir, "other"), Alias: "coolproj", Port: 2000})
+	require.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	_, err = client.Remove(ctx, &healthproto.RemoveRequest{Alias: "coolproj"})
+	require.NoError(t, err)
+
+	_, ok := p.server("coolproj")
+	require.False(t, ok, "server still registered")
+
+	_, err = client.Remove(ctx, &healthproto.RemoveRequest{Alias: "coolproj"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestGRPCHealth(t *testing.T) {
+	p, err := newTestProxy()
+	require.NoError(t, err)
+
+	conn, cleanup := newTestGRPCClient(t, p)
+	defer cleanup()
+
+	client := healthpb.NewHealthClient(conn)
+	ctx := context.Background()
+
+	resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.GetStatus())
+
+	resp, err = client.Check(ctx, &healthpb.HealthCheckRequest{Service: "project1"})
+	require.NoError(t, err)
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, resp.GetStatus())
+
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{Service: "nope"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestGRPCHealthRemovedServers(t *testing.T) {
+	p, err := newTestProxy()
+	require.NoError(t, err)
+
+	g := NewGRPCServer(p, time.Hour)
+	ctx := context.Background()
+	g.probe()
+
+	// Code-servers removed by the REST API are unknown after the next probe
+	rr := doAPIRequest(t, p, "DELETE", "/api/v1/servers/project1", nil)
+	require.Equal(t, http.StatusNoContent, rr.Code)
+	g.probe()
+
+	resp, err := g.health.Check(ctx, &healthpb.HealthCheckRequest{Service: "project1"})
+	require.NoError(t, err)
+	require.Equal(t, healthpb.HealthCheckResponse_SERVICE_UNKNOWN, resp.GetStatus())
+
+	resp, err = g.health.Check(ctx, &healthpb.HealthCheckRequest{Service: "project2"})
+	require.NoError(t, err)
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, resp.GetStatus())
+
+	// Stopping twice is harmless
+	g.Stop()
+	g.Stop()
+}
+
+func TestGRPCWatchHealth(t *testing.T) {
+	p, err := newTestProxy()
+	require.NoError(t, err)
+
+	l := bufconn.Listen(1024 * 1024)
+	g := NewGRPCServer(p, 100*time.Millisecond)
+	go g.Serve(l)
+	defer g.Stop()
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithDialer(func(string, time.Duration) (net.Conn, error) {
+			return l.Dial()
+		}),
+		grpc.WithInsecure(),
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	client := healthproto.NewCodeServerProxyClient(conn)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := client.WatchHealth(ctx, &healthproto.WatchHealthRequest{})
+	require.NoError(t, err)
+
+	healthCheck, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, "OK", healthCheck.GetCodeServerProxy())
+	require.Len(t, healthCheck.GetCodeServers(), 3)
+
+	// The next probe of the server finds a removed code-server
+	require.NoError(t, p.removeServer("project3"))
+
+	healthCheck, err = stream.Recv()
+	require.NoError(t, err)
+	require.Len(t, healthCheck.GetCodeServers(), 2)
+	for _, s := range healthCheck.GetCodeServers() {
+		require.Equal(t, "NOT OK", s.GetState())
+	}
+}
+
+func TestGRPCWatchHealthSharesProbes(t *testing.T) {
+	p, err := newTestProxy()
+	require.NoError(t, err)
+
+	conn, cleanup := newTestGRPCClient(t, p)
+	defer cleanup()
+
+	client := healthproto.NewCodeServerProxyClient(conn)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Watchers get the result of the probe of the server
+	for i := 0; i < 3; i++ {
+		stream, err := client.WatchHealth(ctx, &healthproto.WatchHealthRequest{IntervalSeconds: 1})
+		require.NoError(t, err)
+		healthCheck, err := stream.Recv()
+		require.NoError(t, err)
+		require.Len(t, healthCheck.GetCodeServers(), 3)
+	}
+
+	samples := scrape(t, p)
+	require.Contains(t, samples, `code_server_proxy_probes_total{server="project1",result="not_ok"} 1`)
+}
+
+func TestGRPCGracefulStop(t *testing.T) {
+	p, err := newTestProxy()
+	require.NoError(t, err)
+
+	l := bufconn.Listen(1024 * 1024)
+	g := NewGRPCServer(p, time.Hour)
+	go g.Serve(l)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithDialer(func(string, time.Duration) (net.Conn, error) {
+			return l.Dial()
+		}),
+		grpc.WithInsecure(),
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	stream, err := healthproto.NewCodeServerProxyClient(conn).WatchHealth(context.Background(), &healthproto.WatchHealthRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.NoError(t, err)
+
+	// Streams end, so stopping gracefully doesn't wait for them
+	stopped := make(chan struct{})
+	go func() {
+		g.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("gRPC server didn't stop")
+	}
+
+	_, err = stream.Recv()
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	g.Stop()
+}
@@ -0,0 +1,196 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// hopHeaders are meaningful for a single connection only, so they are not
+// forwarded
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// handshakeHeaders are negotiated by each side of a websocket tunnel on its
+// own, the subprotocol is the only one passed on
+var handshakeHeaders = []string{
+	"Sec-Websocket-Key",
+	"Sec-Websocket-Version",
+	"Sec-Websocket-Accept",
+	"Sec-Websocket-Extensions",
+	"Content-Length",
+}
+
+// copyHeader copies header without the excluded headers
+func copyHeader(header http.Header, excluded ...[]string) http.Header {
+	copied := make(http.Header, len(header))
+	for h, vals := range header {
+		copied[h] = append([]string(nil), vals...)
+	}
+
+	// Headers listed by Connection are hop-by-hop as well
+	for _, vals := range header["Connection"] {
+		for _, h := range strings.Split(vals, ",") {
+			copied.Del(strings.TrimSpace(h))
+		}
+	}
+
+	for _, headers := range excluded {
+		for _, h := range headers {
+			copied.Del(h)
+		}
+	}
+	return copied
+}
+
+// forwardHeader returns the header of r forwarded to the code-server of
+// res. The client is passed on as X-Forwarded-For, -Host and -Proto, the
+// prefix the project is served under as X-Forwarded-Prefix. Compression is
+// disabled if bodies may be rewritten.
+func forwardHeader(r *http.Request, res Resolution, rewriteBody bool) http.Header {
+	forwarded := copyHeader(r.Header, hopHeaders)
+
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if prior := forwarded.Get("X-Forwarded-For"); prior != "" {
+			ip = fmt.Sprintf("%s, %s", prior, ip)
+		}
+		forwarded.Set("X-Forwarded-For", ip)
+	}
+
+	if forwarded.Get("X-Forwarded-Host") == "" && r.Host != "" {
+		forwarded.Set("X-Forwarded-Host", r.Host)
+	}
+
+	if forwarded.Get("X-Forwarded-Proto") == "" {
+		proto := "http"
+		if r.TLS != nil {
+			proto = "https"
+		}
+		forwarded.Set("X-Forwarded-Proto", proto)
+	}
+
+	if res.ProjectPrefix != "" {
+		forwarded.Set("X-Forwarded-Prefix", res.ProjectPrefix)
+		if rewriteBody {
+			forwarded.Del("Accept-Encoding")
+		}
+	}
+	return forwarded
+}
+
+// rewriteResponseHeader maps the URLs and cookies of a code-server response
+// back under the project prefix of res, so that browsers stay in the project
+// and cookies of different projects don't clobber each other on the proxy
+// host. Projects served from the root are left alone.
+func rewriteResponseHeader(header http.Header, r *http.Request, res Resolution) {
+	prefix := res.ProjectPrefix
+	if prefix == "" {
+		return
+	}
+
+	for _, h := range []string{"Location", "Content-Location"} {
+		if v := header.Get(h); v != "" {
+			header.Set(h, rewriteLocation(v, prefix, r.Host, res.Server.Port))
+		}
+	}
+
+	// Cookies of requests by root paths keep their path, browsers don't
+	// send cookies under the project prefix with root path requests
+	if res.StripPrefix == "" {
+		prefix = ""
+	}
+	if cookies, ok := header["Set-Cookie"]; ok {
+		for i, c := range cookies {
+			cookies[i] = rewriteSetCookie(c, prefix)
+		}
+	}
+}
+
+// rewriteLocation adds prefix to location if it points to the code-server
+// on port or to host. Locations of other hosts and relative paths are left
+// alone, the latter are resolved by browsers under the prefix already.
+func rewriteLocation(location, prefix, host string, port int) string {
+	u, err := url.Parse(location)
+	if err != nil {
+		return location
+	}
+
+	if u.Host != "" && !isBackendHost(u.Host, port) && !strings.EqualFold(u.Host, host) {
+		return location
+	}
+	if u.Host == "" && (u.Scheme != "" || !strings.HasPrefix(u.Path, "/")) {
+		return location
+	}
+
+	if u.Path != prefix && !strings.HasPrefix(u.Path, prefix+"/") {
+		u.Path = prefix + u.Path
+		u.RawPath = ""
+	}
+
+	// The code-server is only reachable through the proxy
+	if isBackendHost(u.Host, port) {
+		u.Scheme = ""
+		u.Host = ""
+	}
+	return u.String()
+}
+
+// isBackendHost reports whether host is the code-server on port
+func isBackendHost(host string, port int) bool {
+	hostname, p, err := net.SplitHostPort(host)
+	if err != nil || p != fmt.Sprint(port) {
+		return false
+	}
+	return hostname == "localhost" || net.ParseIP(hostname).IsLoopback()
+}
+
+// rewriteSetCookie scopes the Set-Cookie value c to prefix and the proxy
+// host, the path is kept if prefix is empty. Attributes other than Path and
+// Domain are kept as they are.
+func rewriteSetCookie(c, prefix string) string {
+	parts := strings.Split(c, ";")
+
+	attrs := []string{strings.TrimSpace(parts[0])}
+	cookiePath := ""
+	for _, part := range parts[1:] {
+		attr := strings.TrimSpace(part)
+		name := strings.ToLower(strings.SplitN(attr, "=", 2)[0])
+
+		switch name {
+		case "path":
+			if kv := strings.SplitN(attr, "=", 2); len(kv) == 2 {
+				cookiePath = strings.TrimSpace(kv[1])
+			}
+			continue
+		case "domain":
+			// Host-only cookies are not shared with other hosts
+			continue
+		case "":
+			continue
+		}
+		attrs = append(attrs, attr)
+	}
+
+	switch {
+	case prefix == "":
+	case !strings.HasPrefix(cookiePath, "/") || cookiePath == "/":
+		cookiePath = prefix
+	case !hasPathPrefix(cookiePath, prefix):
+		cookiePath = prefix + cookiePath
+	}
+	if cookiePath != "" {
+		attrs = append(attrs, fmt.Sprintf("Path=%s", cookiePath))
+	}
+
+	return strings.Join(attrs, "; ")
+}
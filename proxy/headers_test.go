@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRewriteLocation(t *testing.T) {
+	data := []struct {
+		location string
+		expected string
+	}{
+		{"/login", "/project1/login"},
+		{"/login?to=%2F", "/project1/login?to=%2F"},
+		{"/project1/login", "/project1/login"},
+		{"/project10/login", "/project1/project10/login"},
+		{"http://localhost:9000/login", "/project1/login"},
+		{"http://127.0.0.1:9000/login#x", "/project1/login#x"},
+		{"https://ide.example.com/login", "https://ide.example.com/project1/login"},
+		// Other hosts and relative paths are left alone
+		{"http://localhost:9001/login", "http://localhost:9001/login"},
+		{"https://github.com/login", "https://github.com/login"},
+		{"//github.com/login", "//github.com/login"},
+		{"login", "login"},
+		{"../login", "../login"},
+	}
+
+	for _, d := range data {
+		require.Equal(t, d.expected, rewriteLocation(d.location, "/project1", "ide.example.com", 9000), d.location)
+	}
+}
+
+func TestRewriteSetCookie(t *testing.T) {
+	data := []struct {
+		cookie   string
+		expected string
+	}{
+		{"key=value", "key=value; Path=/project1"},
+		{"key=value; Path=/", "key=value; Path=/project1"},
+		{"key=value; path=/static; HttpOnly", "key=value; HttpOnly; Path=/project1/static"},
+		{"key=value; Path=/project1/x", "key=value; Path=/project1/x"},
+		{"key=value; Domain=localhost; Secure; SameSite=Lax", "key=value; Secure; SameSite=Lax; Path=/project1"},
+		{"key=value; Path=relative; Max-Age=60", "key=value; Max-Age=60; Path=/project1"},
+		{"key=value; Path=/project10", "key=value; Path=/project1/project10"},
+	}
+
+	for _, d := range data {
+		require.Equal(t, d.expected, rewriteSetCookie(d.cookie, "/project1"), d.cookie)
+	}
+
+	// Without prefix only the domain is dropped
+	require.Equal(t, "key=value; Path=/static", rewriteSetCookie("key=value; Domain=localhost; Path=/static", ""))
+	require.Equal(t, "key=value", rewriteSetCookie("key=value", ""))
+}
+
+func TestForwardResponseHeader(t *testing.T) {
+	port, closeBackend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "key", Value: "value", Path: "/", Domain: "localhost"})
+		http.Redirect(w, r, "/login", http.StatusFound)
+	})
+	defer closeBackend()
+
+	p, err := NewProxy(
+		UseCode(Code{
+			Servers: []Server{{Path: "/a/b/c", Alias: "project1", Port: port}},
+			Routing: Routing{BaseDomain: "ide.example.com"},
+		}),
+		UseLogger(logrus.New()),
+	)
+	require.NoError(t, err)
+
+	// Redirects are passed on under the project prefix
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, httptest.NewRequest("GET", "/project1/", nil))
+	require.Equal(t, http.StatusFound, rr.Code)
+	require.Equal(t, "/project1/login", rr.Header().Get("Location"))
+
+	cookies := rr.Result().Cookies()
+	require.Len(t, cookies, 1)
+	require.Equal(t, "/project1", cookies[0].Path)
+	require.Empty(t, cookies[0].Domain)
+
+	// Parent directories redirect to the project path and scope cookies to it
+	rr = httptest.NewRecorder()
+	p.ServeHTTP(rr, httptest.NewRequest("GET", "/a/b/x", nil))
+	require.Equal(t, "/a/b/c/login", rr.Header().Get("Location"))
+	require.Equal(t, "/a/b/c", rr.Result().Cookies()[0].Path)
+
+	// Cookies of requests by root paths are sent with root paths
+	req := httptest.NewRequest("GET", "/static/x.js", nil)
+	req.Header.Set("Referer", "http://localhost/project1/")
+	rr = httptest.NewRecorder()
+	p.ServeHTTP(rr, req)
+	require.Equal(t, "/project1/login", rr.Header().Get("Location"))
+	require.Equal(t, "/", rr.Result().Cookies()[0].Path)
+	require.Empty(t, rr.Result().Cookies()[0].Domain)
+
+	// Projects on subdomains are served from the root
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Host = "project1.ide.example.com"
+	rr = httptest.NewRecorder()
+	p.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusFound, rr.Code)
+	require.Equal(t, "/login", rr.Header().Get("Location"))
+	require.Equal(t, "/", rr.Result().Cookies()[0].Path)
+}
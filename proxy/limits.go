@@ -0,0 +1,187 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+// Reasons of limit violations, sent with the close frames
+const (
+	violationMessageSize = "message too big"
+	violationMessageRate = "message rate exceeded"
+	violationByteRate    = "byte rate exceeded"
+	violationSessions    = "too many sessions"
+)
+
+// Limits caps the messages and sessions of code-servers. Violations end
+// sessions with 1008 Policy Violation. Zero disables a limit.
+type Limits struct {
+	// MaxMessageSize is the size in bytes of the largest message
+	MaxMessageSize int64 `yaml:"maxMessageSize,omitempty"`
+	// MessagesPerSecond is the rate of messages in either direction of a
+	// session, with a burst of one second
+	MessagesPerSecond float64 `yaml:"messagesPerSecond,omitempty"`
+	// BytesPerSecond is the rate of bytes in either direction of a
+	// session, with a burst of one second. It caps the size of messages as
+	// well, so it must not be below MaxMessageSize.
+	BytesPerSecond float64 `yaml:"bytesPerSecond,omitempty"`
+	// MaxSessionsPerServer is the number of concurrent sessions of a
+	// code-server
+	MaxSessionsPerServer int `yaml:"maxSessionsPerServer,omitempty"`
+}
+
+// validateLimits checks that messages of the maximum size fit the byte rate
+func validateLimits(l Limits) error {
+	if l.BytesPerSecond > 0 && float64(l.MaxMessageSize) > l.BytesPerSecond {
+		return errors.New("Websocket maxMessageSize must not exceed bytesPerSecond")
+	}
+	return nil
+}
+
+// limitError is the violation of a limit by a side of a session
+type limitError struct {
+	reason string
+}
+
+func (e *limitError) Error() string {
+	return e.reason
+}
+
+// LimitViolations counts the sessions ended for violating a limit
+type LimitViolations struct {
+	MessageSize int64 `json:"messageSize"`
+	MessageRate int64 `json:"messageRate"`
+	ByteRate    int64 `json:"byteRate"`
+	Sessions    int64 `json:"sessions"`
+}
+
+// violationCounters counts limit violations, accessed atomically
+type violationCounters struct {
+	messageSize int64
+	messageRate int64
+	byteRate    int64
+	sessions    int64
+}
+
+// add counts a violation for reason
+func (c *violationCounters) add(reason string) {
+	switch reason {
+	case violationMessageSize:
+		atomic.AddInt64(&c.messageSize, 1)
+	case violationMessageRate:
+		atomic.AddInt64(&c.messageRate, 1)
+	case violationByteRate:
+		atomic.AddInt64(&c.byteRate, 1)
+	case violationSessions:
+		atomic.AddInt64(&c.sessions, 1)
+	}
+}
+
+func (c *violationCounters) violations() LimitViolations {
+	return LimitViolations{
+		MessageSize: atomic.LoadInt64(&c.messageSize),
+		MessageRate: atomic.LoadInt64(&c.messageRate),
+		ByteRate:    atomic.LoadInt64(&c.byteRate),
+		Sessions:    atomic.LoadInt64(&c.sessions),
+	}
+}
+
+// rateLimiter is a token bucket holding up to one second of its rate. A nil
+// rateLimiter allows everything. It is used by a single pump, so it isn't
+// guarded.
+type rateLimiter struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns a full bucket of rate, or nil if rate is not positive
+func newRateLimiter(rate float64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: rate, tokens: rate, last: time.Now()}
+}
+
+// take takes n tokens, and reports whether there were enough
+func (l *rateLimiter) take(n float64) bool {
+	if l == nil {
+		return true
+	}
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+
+	if l.tokens < n {
+		return false
+	}
+	l.tokens -= n
+	return true
+}
+
+// directionLimiter limits the rates of one direction of a session
+type directionLimiter struct {
+	messages *rateLimiter
+	bytes    *rateLimiter
+}
+
+func newDirectionLimiter(l Limits) directionLimiter {
+	return directionLimiter{
+		messages: newRateLimiter(l.MessagesPerSecond),
+		bytes:    newRateLimiter(l.BytesPerSecond),
+	}
+}
+
+// acquire counts a new session of alias, unless it has max sessions already.
+// Zero max is unlimited.
+func (reg *sessionRegistry) acquire(alias string, max int) bool {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if max > 0 && reg.active[alias] >= max {
+		return false
+	}
+	if reg.active == nil {
+		reg.active = map[string]int{}
+	}
+	reg.active[alias]++
+	return true
+}
+
+// release uncounts a session of alias
+func (reg *sessionRegistry) release(alias string) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if reg.active[alias]--; reg.active[alias] <= 0 {
+		delete(reg.active, alias)
+	}
+}
+
+// rejectSession ends the handshake of a session over the session limit of
+// its code-server. Browsers can't see the status of failed handshakes, so
+// it is accepted and closed with a policy violation.
+func (p *Proxy) rejectSession(w http.ResponseWriter, r *http.Request, res Resolution) {
+	p.counters.violations.add(violationSessions)
+	p.logger.WithFields(logrus.Fields{
+		"server": res.Server.Alias,
+		"limit":  p.websocket.Limits.MaxSessionsPerServer,
+	}).Warn("Rejected websocket session over the session limit")
+
+	front, err := p.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer front.Close()
+	writeClose(front, websocket.ClosePolicyViolation, violationSessions)
+	accessRecorderOf(r).rejected(violationSessions)
+}
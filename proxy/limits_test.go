@@ -0,0 +1,191 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+// violations returns the limit violations of p
+func violations(t *testing.T, p *Proxy) LimitViolations {
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "/debug/websocket", nil))
+
+	var stats WebsocketStats
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	return stats.Violations
+}
+
+// requirePolicyViolation reads from conn until it is closed with a policy
+// violation for reason
+func requirePolicyViolation(t *testing.T, conn *websocket.Conn, reason string) {
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		require.True(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation), "%v", err)
+		require.Contains(t, err.Error(), reason)
+		return
+	}
+}
+
+func TestWebsocketMessageSizeLimit(t *testing.T) {
+	received := make(chan []byte, 2)
+	upgrader := websocket.Upgrader{CheckOrigin: anyOrigin}
+	p, wsURL, cleanup := newTestWebsocketProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			received <- msg
+		}
+	}, useWebsocket(Websocket{Limits: Limits{MaxMessageSize: 10}}))
+	defer cleanup()
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"/project1/", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("0123456789")))
+	require.Equal(t, "0123456789", string(<-received))
+
+	// Messages over the limit don't reach the code-server
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, bytes.Repeat([]byte("x"), 100)))
+	requirePolicyViolation(t, conn, violationMessageSize)
+	waitSessions(t, p)
+	require.Empty(t, received)
+
+	require.Equal(t, LimitViolations{MessageSize: 1}, violations(t, p))
+}
+
+func TestWebsocketMessageRateLimit(t *testing.T) {
+	p, wsURL, cleanup := newTestWebsocketProxy(t, echo(websocket.Upgrader{CheckOrigin: anyOrigin}, nil),
+		useWebsocket(Websocket{Limits: Limits{MessagesPerSecond: 2}}))
+	defer cleanup()
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"/project1/", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("hello")))
+	}
+	requirePolicyViolation(t, conn, violationMessageRate)
+	waitSessions(t, p)
+
+	require.Equal(t, int64(1), violations(t, p).MessageRate)
+}
+
+func TestWebsocketByteRateLimit(t *testing.T) {
+	received := make(chan []byte, 2)
+	upgrader := websocket.Upgrader{CheckOrigin: anyOrigin}
+	p, wsURL, cleanup := newTestWebsocketProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			mt, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			received <- msg
+			if err := conn.WriteMessage(mt, msg); err != nil {
+				return
+			}
+		}
+	}, useWebsocket(Websocket{Limits: Limits{BytesPerSecond: 100}}))
+	defer cleanup()
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"/project1/", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	msg := bytes.Repeat([]byte("x"), 60)
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, msg))
+	_, echoed, err := conn.ReadMessage()
+	require.NoError(t, err)
+	require.Equal(t, msg, echoed)
+
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, msg))
+	requirePolicyViolation(t, conn, violationByteRate)
+	waitSessions(t, p)
+
+	// Messages over the rate don't reach the code-server
+	require.Len(t, received, 1)
+	require.Equal(t, int64(1), violations(t, p).ByteRate)
+}
+
+func TestInvalidLimits(t *testing.T) {
+	require.Error(t, validateLimits(Limits{MaxMessageSize: 200, BytesPerSecond: 100}))
+	require.NoError(t, validateLimits(Limits{MaxMessageSize: 100, BytesPerSecond: 100}))
+	require.NoError(t, validateLimits(Limits{MaxMessageSize: 200}))
+}
+
+func TestWebsocketSessionLimit(t *testing.T) {
+	p, wsURL, cleanup := newTestWebsocketProxy(t, echo(websocket.Upgrader{CheckOrigin: anyOrigin}, nil),
+		useWebsocket(Websocket{Limits: Limits{MaxSessionsPerServer: 1}}))
+	defer cleanup()
+
+	first, _, err := websocket.DefaultDialer.Dial(wsURL+"/project1/", nil)
+	require.NoError(t, err)
+	defer first.Close()
+
+	second, _, err := websocket.DefaultDialer.Dial(wsURL+"/project1/", nil)
+	require.NoError(t, err)
+	defer second.Close()
+	requirePolicyViolation(t, second, violationSessions)
+	require.Equal(t, int64(1), violations(t, p).Sessions)
+
+	// Ended sessions free their slot
+	first.Close()
+	waitSessions(t, p)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		third, _, err := websocket.DefaultDialer.Dial(wsURL+"/project1/", nil)
+		require.NoError(t, err)
+
+		third.WriteMessage(websocket.TextMessage, []byte("hello"))
+		_, _, err = third.ReadMessage()
+		third.Close()
+		if err == nil {
+			break
+		}
+		require.True(t, time.Now().Before(deadline), "slot was not freed")
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	var unlimited *rateLimiter
+	require.True(t, unlimited.take(1e9))
+	require.Nil(t, newRateLimiter(0))
+
+	l := newRateLimiter(10)
+	require.True(t, l.take(10))
+	require.False(t, l.take(1))
+
+	// Tokens refill at the rate, up to one second
+	l.last = l.last.Add(-200 * time.Millisecond)
+	require.True(t, l.take(1.5))
+	require.False(t, l.take(1))
+
+	l.last = l.last.Add(-time.Hour)
+	require.False(t, l.take(11))
+	require.True(t, l.take(10))
+}
@@ -0,0 +1,333 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// metricsPrefix is the namespace of the metrics of code-server-proxy
+const metricsPrefix = "code_server_proxy_"
+
+// contentTypeMetrics is the Prometheus text exposition format
+const contentTypeMetrics = "text/plain; version=0.0.4; charset=utf-8"
+
+// Results of health probes
+const (
+	probeOK    = "ok"
+	probeNotOK = "not_ok"
+	probeError = "error"
+)
+
+// durationBuckets are the upper bounds in seconds of latency histograms
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// histogram counts observations by bucket
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]uint64, len(durationBuckets))}
+}
+
+// observe adds v to the buckets it fits in
+func (h *histogram) observe(v float64) {
+	for i, bound := range durationBuckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// websocketMetrics counts the websocket bytes of a code-server, accessed
+// atomically
+type websocketMetrics struct {
+	bytesIn  int64
+	bytesOut int64
+}
+
+// add counts n bytes sent to the dstSide leg of a session
+func (wm *websocketMetrics) add(dstSide string, n int64) {
+	if dstSide == sideBackend {
+		atomic.AddInt64(&wm.bytesIn, n)
+		return
+	}
+	atomic.AddInt64(&wm.bytesOut, n)
+}
+
+// labelKey identifies a series by its label values
+type labelKey struct {
+	server string
+	value  string
+}
+
+// metrics collects the series of GET /metrics
+type metrics struct {
+	mu                  sync.Mutex
+	requests            map[labelKey]*histogram
+	backendErrors       map[string]int64
+	probes              map[labelKey]int64
+	probeDurations      map[string]*histogram
+	probeUp             map[string]bool
+	websocket           map[string]*websocketMetrics
+	configWriteFailures int64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests:       map[labelKey]*histogram{},
+		backendErrors:  map[string]int64{},
+		probes:         map[labelKey]int64{},
+		probeDurations: map[string]*histogram{},
+		probeUp:        map[string]bool{},
+		websocket:      map[string]*websocketMetrics{},
+	}
+}
+
+// observeRequest records a forwarded request to server answered with code
+func (m *metrics) observeRequest(server string, code int, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := labelKey{server, strconv.Itoa(code)}
+	h, ok := m.requests[key]
+	if !ok {
+		h = newHistogram()
+		m.requests[key] = h
+	}
+	h.observe(d.Seconds())
+}
+
+// backendError records a failure to reach server
+func (m *metrics) backendError(server string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.backendErrors[server]++
+}
+
+// observeProbe records a health probe of server
+func (m *metrics) observeProbe(server, result string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.probes[labelKey{server, result}]++
+	m.probeUp[server] = result == probeOK
+
+	h, ok := m.probeDurations[server]
+	if !ok {
+		h = newHistogram()
+		m.probeDurations[server] = h
+	}
+	h.observe(d.Seconds())
+}
+
+// configWriteFailed records a failure to persist the registry
+func (m *metrics) configWriteFailed() {
+	atomic.AddInt64(&m.configWriteFailures, 1)
+}
+
+// websocketServer returns the websocket counters of server
+func (m *metrics) websocketServer(server string) *websocketMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	wm, ok := m.websocket[server]
+	if !ok {
+		wm = &websocketMetrics{}
+		m.websocket[server] = wm
+	}
+	return wm
+}
+
+// probeResult returns the metrics result of a health probe
+func probeResult(state string, err error) string {
+	switch {
+	case err != nil:
+		return probeError
+	case state == "OK":
+		return probeOK
+	}
+	return probeNotOK
+}
+
+// exposition writes series in the Prometheus text format
+type exposition struct {
+	bytes.Buffer
+}
+
+// family starts the metric family name
+func (e *exposition) family(name, typ, help string) {
+	fmt.Fprintf(e, "# HELP %s%s %s\n# TYPE %s%s %s\n", metricsPrefix, name, help, metricsPrefix, name, typ)
+}
+
+// sample writes a sample of name, labels are pairs of names and values
+func (e *exposition) sample(name string, value float64, labels ...string) {
+	e.WriteString(metricsPrefix + name)
+	if len(labels) > 0 {
+		e.WriteByte('{')
+		for i := 0; i < len(labels); i += 2 {
+			if i > 0 {
+				e.WriteByte(',')
+			}
+			fmt.Fprintf(e, "%s=\"%s\"", labels[i], escapeLabel(labels[i+1]))
+		}
+		e.WriteByte('}')
+	}
+	fmt.Fprintf(e, " %s\n", strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// histogram writes the buckets, sum and count of h
+func (e *exposition) histogram(name string, h *histogram, labels ...string) {
+	for i, bound := range durationBuckets {
+		e.sample(name+"_bucket", float64(h.counts[i]), append(labels, "le", strconv.FormatFloat(bound, 'g', -1, 64))...)
+	}
+	e.sample(name+"_bucket", float64(h.count), append(labels, "le", "+Inf")...)
+	e.sample(name+"_sum", h.sum, labels...)
+	e.sample(name+"_count", float64(h.count), labels...)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+// sortedKeys returns the keys of m in order
+func sortedKeys(m map[labelKey]int64) []labelKey {
+	keys := make([]labelKey, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sortLabelKeys(keys)
+	return keys
+}
+
+func sortLabelKeys(keys []labelKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].server != keys[j].server {
+			return keys[i].server < keys[j].server
+		}
+		return keys[i].value < keys[j].value
+	})
+}
+
+// sortedServers returns the server keys of the map m in order
+func sortedServers(m interface{}) []string {
+	var servers []string
+	for _, k := range reflect.ValueOf(m).MapKeys() {
+		servers = append(servers, k.String())
+	}
+	sort.Strings(servers)
+	return servers
+}
+
+// write writes the series collected by m
+func (m *metrics) write(e *exposition) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]labelKey, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	sortLabelKeys(keys)
+
+	e.family("requests_total", "counter", "Requests forwarded to code-servers by status code.")
+	for _, k := range keys {
+		e.sample("requests_total", float64(m.requests[k].count), "server", k.server, "code", k.value)
+	}
+	e.family("request_duration_seconds", "histogram", "Latency of requests forwarded to code-servers by status code.")
+	for _, k := range keys {
+		e.histogram("request_duration_seconds", m.requests[k], "server", k.server, "code", k.value)
+	}
+
+	e.family("backend_errors_total", "counter", "Requests and websocket handshakes which failed to reach code-servers.")
+	for _, s := range sortedServers(m.backendErrors) {
+		e.sample("backend_errors_total", float64(m.backendErrors[s]), "server", s)
+	}
+
+	e.family("websocket_bytes_total", "counter", "Websocket message bytes from (in) and to (out) browsers.")
+	for _, s := range sortedServers(m.websocket) {
+		wm := m.websocket[s]
+		e.sample("websocket_bytes_total", float64(atomic.LoadInt64(&wm.bytesIn)), "server", s, "direction", "in")
+		e.sample("websocket_bytes_total", float64(atomic.LoadInt64(&wm.bytesOut)), "server", s, "direction", "out")
+	}
+
+	e.family("probes_total", "counter", "Health probes of code-servers by result.")
+	for _, k := range sortedKeys(m.probes) {
+		e.sample("probes_total", float64(m.probes[k]), "server", k.server, "result", k.value)
+	}
+	e.family("probe_up", "gauge", "Whether the last health probe of a code-server succeeded.")
+	for _, s := range sortedServers(m.probeUp) {
+		up := 0.0
+		if m.probeUp[s] {
+			up = 1
+		}
+		e.sample("probe_up", up, "server", s)
+	}
+	e.family("probe_duration_seconds", "histogram", "Latency of health probes of code-servers.")
+	for _, s := range sortedServers(m.probeDurations) {
+		e.histogram("probe_duration_seconds", m.probeDurations[s], "server", s)
+	}
+
+	e.family("config_write_failures_total", "counter", "Failures to persist the registry to the config file.")
+	e.sample("config_write_failures_total", float64(atomic.LoadInt64(&m.configWriteFailures)))
+}
+
+// metricsHandler handles GET /metrics
+func (p *Proxy) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	e := &exposition{}
+	p.metrics.write(e)
+
+	e.family("registered_servers", "gauge", "Code-servers in the registry.")
+	e.sample("registered_servers", float64(len(p.servers())))
+
+	active := p.sessions.count()
+	e.family("websocket_sessions", "gauge", "Active websocket sessions.")
+	for _, s := range sortedServers(active) {
+		e.sample("websocket_sessions", float64(active[s]), "server", s)
+	}
+
+	stats := WebsocketStats{
+		Frontend:   p.counters.frontend.stats(),
+		Backend:    p.counters.backend.stats(),
+		Violations: p.counters.violations.violations(),
+	}
+	e.family("websocket_payload_bytes_total", "counter", "Websocket message bytes by leg.")
+	e.sample("websocket_payload_bytes_total", float64(stats.Frontend.PayloadBytes), "leg", sideFrontend)
+	e.sample("websocket_payload_bytes_total", float64(stats.Backend.PayloadBytes), "leg", sideBackend)
+	e.family("websocket_wire_bytes_total", "counter", "Websocket bytes on the wire by leg, the difference to the payload is saved by compression.")
+	e.sample("websocket_wire_bytes_total", float64(stats.Frontend.WireBytes), "leg", sideFrontend)
+	e.sample("websocket_wire_bytes_total", float64(stats.Backend.WireBytes), "leg", sideBackend)
+
+	e.family("websocket_limit_violations_total", "counter", "Websocket sessions ended for violating a limit.")
+	for _, v := range []struct {
+		limit string
+		count int64
+	}{
+		{"message_size", stats.Violations.MessageSize},
+		{"message_rate", stats.Violations.MessageRate},
+		{"byte_rate", stats.Violations.ByteRate},
+		{"sessions", stats.Violations.Sessions},
+	} {
+		e.sample("websocket_limit_violations_total", float64(v.count), "limit", v.limit)
+	}
+
+	w.Header().Set("Content-Type", contentTypeMetrics)
+	if _, err := w.Write(e.Bytes()); err != nil {
+		p.logger.Errorf("Failed to write metrics: %v", err)
+	}
+}
@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+// scrape returns the samples of GET /metrics of p
+func scrape(t *testing.T, p *Proxy) []string {
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, contentTypeMetrics, w.Header().Get("Content-Type"))
+	return strings.Split(w.Body.String(), "\n")
+}
+
+func TestMetricsRequests(t *testing.T) {
+	p, wsURL, cleanup := newTestWebsocketProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, "hello")
+	})
+	defer cleanup()
+
+	baseURL := strings.Replace(wsURL, "ws://", "http://", 1)
+	for _, path := range []string{"/project1/", "/project1/", "/project1/missing"} {
+		resp, err := http.Get(baseURL + path)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	samples := scrape(t, p)
+	require.Contains(t, samples, `code_server_proxy_requests_total{server="project1",code="200"} 2`)
+	require.Contains(t, samples, `code_server_proxy_requests_total{server="project1",code="404"} 1`)
+	require.Contains(t, samples, `code_server_proxy_request_duration_seconds_bucket{server="project1",code="200",le="+Inf"} 2`)
+	require.Contains(t, samples, `code_server_proxy_request_duration_seconds_count{server="project1",code="404"} 1`)
+	require.Contains(t, samples, `code_server_proxy_registered_servers 1`)
+	require.Contains(t, samples, `code_server_proxy_config_write_failures_total 0`)
+}
+
+func TestMetricsBackendErrors(t *testing.T) {
+	port, closeBackend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {})
+	closeBackend()
+
+	p, err := NewProxy(UseLogger(logrus.New()), UseCode(Code{Servers: []Server{{Path: "/a/b/c", Alias: "project1", Port: port}}}))
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "/project1/", nil))
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+
+	samples := scrape(t, p)
+	require.Contains(t, samples, `code_server_proxy_backend_errors_total{server="project1"} 1`)
+	require.Contains(t, samples, `code_server_proxy_requests_total{server="project1",code="500"} 1`)
+}
+
+func TestMetricsProbes(t *testing.T) {
+	up, closeUp := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"hostname":"ide"}`)
+	})
+	defer closeUp()
+	down, closeDown := newTestBackend(t, http.NotFound)
+	defer closeDown()
+
+	p, err := NewProxy(UseLogger(logrus.New()), UseCode(Code{Servers: []Server{
+		{Path: "/a/b/c", Alias: "project1", Port: up},
+		{Path: "/a/b/d", Alias: "project2", Port: down},
+	}}))
+	require.NoError(t, err)
+
+	p.healthCheck("")
+
+	samples := scrape(t, p)
+	require.Contains(t, samples, `code_server_proxy_probes_total{server="project1",result="ok"} 1`)
+	require.Contains(t, samples, `code_server_proxy_probes_total{server="project2",result="not_ok"} 1`)
+	require.Contains(t, samples, `code_server_proxy_probe_up{server="project1"} 1`)
+	require.Contains(t, samples, `code_server_proxy_probe_up{server="project2"} 0`)
+	require.Contains(t, samples, `code_server_proxy_probe_duration_seconds_count{server="project1"} 1`)
+}
+
+func TestMetricsWebsocket(t *testing.T) {
+	p, wsURL, cleanup := newTestWebsocketProxy(t, echo(websocket.Upgrader{CheckOrigin: anyOrigin}, nil))
+	defer cleanup()
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"/project1/", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("hello")))
+	_, _, err = conn.ReadMessage()
+	require.NoError(t, err)
+
+	samples := scrape(t, p)
+	require.Contains(t, samples, `code_server_proxy_websocket_sessions{server="project1"} 1`)
+	require.Contains(t, samples, `code_server_proxy_websocket_bytes_total{server="project1",direction="in"} 5`)
+	require.Contains(t, samples, `code_server_proxy_websocket_bytes_total{server="project1",direction="out"} 5`)
+	require.Contains(t, samples, `code_server_proxy_websocket_payload_bytes_total{leg="frontend"} 10`)
+
+	conn.Close()
+	waitSessions(t, p)
+	require.NotContains(t, scrape(t, p), `code_server_proxy_websocket_sessions{server="project1"} 1`)
+}
+
+func TestMetricsConfigWriteFailures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "code-server-proxy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// The directory of the config is missing
+	p, err := NewProxy(
+		UseLogger(logrus.New()),
+		UseCode(Code{Servers: []Server{{Path: "/a/b/c", Alias: "project1", Port: 8888}}}),
+		UseConfig(filepath.Join(dir, "missing", "config.yaml")),
+	)
+	require.NoError(t, err)
+
+	rr := doAPIRequest(t, p, "DELETE", "/api/v1/servers/project1", nil)
+	require.Equal(t, http.StatusNoContent, rr.Code)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		samples := scrape(t, p)
+		require.Contains(t, samples, `code_server_proxy_registered_servers 0`)
+		if contains(samples, `code_server_proxy_config_write_failures_total 1`) {
+			break
+		}
+		require.True(t, time.Now().Before(deadline), "config write failure was not counted")
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMetricsLabelEscaping(t *testing.T) {
+	e := &exposition{}
+	e.sample("test", 1.5, "server", "a\"b\\c\nd")
+	require.Equal(t, "code_server_proxy_test{server=\"a\\\"b\\\\c\\nd\"} 1.5\n", e.String())
+}
+
+func contains(lines []string, line string) bool {
+	for _, l := range lines {
+		if l == line {
+			return true
+		}
+	}
+	return false
+}
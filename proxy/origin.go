@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/publicsuffix"
+)
+
+// Origin policies
+const (
+	// OriginSameOrigin allows origins of the requested host
+	OriginSameOrigin = "same-origin"
+	// OriginSameSite allows origins of the requested site, e.g.
+	// ide.example.com for requests to example.com, or any subdomain of the
+	// base domain
+	OriginSameSite = "same-site"
+	// OriginAllowList allows the listed origins only
+	OriginAllowList = "allowlist"
+	// OriginAny allows every origin
+	OriginAny = "any"
+)
+
+// OriginPolicy configures the origins allowed to open websockets and to
+// change the registry. Requests without Origin, e.g. of csp-cli, are not
+// subject to the policy.
+type OriginPolicy struct {
+	// Policy is same-origin, same-site, allowlist or any, same-origin if empty
+	Policy string `yaml:"policy,omitempty"`
+	// Allow are origins allowed in addition, e.g. https://ide.example.com
+	Allow []string `yaml:"allow,omitempty"`
+	// AllowPatterns are regular expressions of origins allowed in addition,
+	// they must match the whole origin
+	AllowPatterns []string `yaml:"allowPatterns,omitempty"`
+}
+
+// originChecker applies an origin policy
+type originChecker struct {
+	policy     string
+	allow      map[string]bool
+	patterns   []*regexp.Regexp
+	baseDomain string
+}
+
+// newOriginChecker compiles the origin policy o
+func newOriginChecker(o OriginPolicy, baseDomain string) (*originChecker, error) {
+	c := &originChecker{policy: o.Policy, allow: map[string]bool{}, baseDomain: baseDomain}
+
+	switch o.Policy {
+	case "":
+		c.policy = OriginSameOrigin
+	case OriginSameOrigin, OriginSameSite, OriginAllowList, OriginAny:
+	default:
+		return nil, fmt.Errorf("Unknown origin policy: %s", o.Policy)
+	}
+
+	for _, origin := range o.Allow {
+		c.allow[strings.ToLower(strings.TrimSuffix(origin, "/"))] = true
+	}
+	for _, pattern := range o.AllowPatterns {
+		// Patterns match whole origins
+		re, err := regexp.Compile(fmt.Sprintf("^(?:%s)$", pattern))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid origin pattern %s: %v", pattern, err)
+		}
+		c.patterns = append(c.patterns, re)
+	}
+	return c, nil
+}
+
+// allows reports whether the origin of r is allowed
+func (c *originChecker) allows(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || c.policy == OriginAny {
+		return true
+	}
+
+	if c.allow[strings.ToLower(origin)] {
+		return true
+	}
+	for _, re := range c.patterns {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+
+	switch c.policy {
+	case OriginSameOrigin:
+		return strings.EqualFold(u.Host, r.Host)
+	case OriginSameSite:
+		return c.site(u.Hostname()) == c.site(hostname(r.Host))
+	}
+	return false
+}
+
+// site returns the base domain if host is under it, or the registrable
+// domain of host by the public suffix list otherwise, e.g. example.co.uk of
+// ide.example.co.uk, but a.herokuapp.com of a.herokuapp.com
+func (c *originChecker) site(host string) string {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if c.baseDomain != "" && (host == c.baseDomain || strings.HasSuffix(host, "."+c.baseDomain)) {
+		return c.baseDomain
+	}
+	if net.ParseIP(host) != nil {
+		return host
+	}
+
+	site, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		// host is a public suffix itself, e.g. localhost
+		return host
+	}
+	return site
+}
+
+// hostname strips the port of host
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+// rejectOrigin logs the rejection of r for its origin
+func (p *Proxy) rejectOrigin(r *http.Request) {
+	p.logger.WithFields(logrus.Fields{
+		"origin": r.Header.Get("Origin"),
+		"host":   r.Host,
+		"method": r.Method,
+		"path":   r.URL.Path,
+		"policy": p.origin.policy,
+	}).Warn("Rejected request of disallowed origin")
+}
+
+// requireOrigin guards the state-changing management endpoint h by the
+// origin policy
+func (p *Proxy) requireOrigin(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !p.origin.allows(r) {
+			p.rejectOrigin(r)
+			p.writeAPIError(w, r, &APIError{
+				Status:  http.StatusForbidden,
+				Code:    ErrCodeForbidden,
+				Message: fmt.Sprintf("Origin %s is not allowed", r.Header.Get("Origin")),
+			})
+			return
+		}
+		h(w, r)
+	}
+}
@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOriginPolicy(t *testing.T) {
+	tests := []struct {
+		policy  OriginPolicy
+		host    string
+		origin  string
+		allowed bool
+	}{
+		{OriginPolicy{}, "ide.example.com", "", true},
+		{OriginPolicy{}, "ide.example.com", "https://ide.example.com", true},
+		{OriginPolicy{}, "ide.example.com", "https://IDE.example.com", true},
+		{OriginPolicy{}, "ide.example.com:8080", "http://ide.example.com:8080", true},
+		{OriginPolicy{}, "ide.example.com", "https://evil.example.com", false},
+		{OriginPolicy{}, "ide.example.com", "null", false},
+		{OriginPolicy{Policy: OriginSameSite}, "ide.example.com", "https://www.example.com", true},
+		{OriginPolicy{Policy: OriginSameSite}, "example.com:8080", "https://ide.example.com", true},
+		{OriginPolicy{Policy: OriginSameSite}, "ide.example.com", "https://example.org", false},
+		{OriginPolicy{Policy: OriginSameSite}, "127.0.0.1:8080", "http://127.0.0.1:3000", true},
+		{OriginPolicy{Policy: OriginSameSite}, "ide.example.co.uk", "https://www.example.co.uk", true},
+		{OriginPolicy{Policy: OriginSameSite}, "a.co.uk", "https://b.co.uk", false},
+		{OriginPolicy{Policy: OriginSameSite}, "victim.herokuapp.com", "https://evil.herokuapp.com", false},
+		{OriginPolicy{Policy: OriginSameSite}, "localhost:8080", "http://localhost:3000", true},
+		{OriginPolicy{Policy: OriginAllowList}, "ide.example.com", "https://ide.example.com", false},
+		{OriginPolicy{Policy: OriginAllowList, Allow: []string{"https://ide.example.com/"}}, "localhost", "https://ide.example.com", true},
+		{OriginPolicy{Policy: OriginAllowList, AllowPatterns: []string{`^https://[a-z]+\.corp\.example$`}}, "localhost", "https://dev.corp.example", true},
+		{OriginPolicy{Policy: OriginAllowList, AllowPatterns: []string{`^https://[a-z]+\.corp\.example$`}}, "localhost", "https://dev.corp.example.evil", false},
+		{OriginPolicy{Policy: OriginAllowList, AllowPatterns: []string{`https://[a-z]+\.corp\.example`}}, "localhost", "https://dev.corp.example.evil", false},
+		{OriginPolicy{Policy: OriginAllowList, AllowPatterns: []string{`https://a|https://b`}}, "localhost", "https://b.evil", false},
+		{OriginPolicy{Allow: []string{"https://portal.example.org"}}, "ide.example.com", "https://portal.example.org", true},
+		{OriginPolicy{Policy: OriginAny}, "ide.example.com", "https://evil.example.org", true},
+	}
+
+	for _, test := range tests {
+		c, err := newOriginChecker(test.policy, "")
+		require.NoError(t, err)
+
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Host = test.host
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+		require.Equal(t, test.allowed, c.allows(r), "%+v", test)
+	}
+}
+
+func TestOriginPolicyBaseDomain(t *testing.T) {
+	c, err := newOriginChecker(OriginPolicy{Policy: OriginSameSite}, "ide.example.co.uk")
+	require.NoError(t, err)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Host = "project1.ide.example.co.uk"
+	r.Header.Set("Origin", "https://ide.example.co.uk")
+	require.True(t, c.allows(r))
+
+	r.Header.Set("Origin", "https://other.co.uk")
+	require.False(t, c.allows(r))
+}
+
+func TestInvalidOriginPolicy(t *testing.T) {
+	for _, o := range []OriginPolicy{{Policy: "strict"}, {AllowPatterns: []string{"("}}} {
+		_, err := NewProxy(UseLogger(logrus.New()), UseCode(Code{Origin: o}))
+		require.Error(t, err, "%+v", o)
+	}
+}
+
+func TestOriginManagementEndpoints(t *testing.T) {
+	p, err := NewProxy(UseLogger(logrus.New()), UseCode(Code{Servers: []Server{{Path: "/a/b/c", Alias: "project1", Port: 8888}}}))
+	require.NoError(t, err)
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest("POST", "/api/v1/servers", bytes.NewBufferString(`{}`)),
+		httptest.NewRequest("PUT", "/api/v1/servers/project1", bytes.NewBufferString(`{}`)),
+		httptest.NewRequest("PATCH", "/api/v1/servers/project1", bytes.NewBufferString(`{}`)),
+		httptest.NewRequest("DELETE", "/api/v1/servers/project1", nil),
+		httptest.NewRequest("POST", "/register", bytes.NewBufferString(`{}`)),
+		httptest.NewRequest("DELETE", "/remove/project1", nil),
+		httptest.NewRequest("DELETE", "/sessions/1", nil),
+	} {
+		req.Header.Set("Origin", "https://evil.example.org")
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, req)
+		require.Equal(t, http.StatusForbidden, w.Code, "%s %s", req.Method, req.URL)
+		require.Contains(t, w.Body.String(), ErrCodeForbidden)
+	}
+
+	// Reading is not state-changing
+	req := httptest.NewRequest("GET", "/api/v1/servers/project1", nil)
+	req.Header.Set("Origin", "https://evil.example.org")
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	_, ok := p.server("project1")
+	require.True(t, ok)
+}
+
+func TestOriginWebsocket(t *testing.T) {
+	dialed := false
+	backend := echo(websocket.Upgrader{CheckOrigin: anyOrigin}, nil)
+	_, wsURL, cleanup := newTestWebsocketProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		dialed = true
+		backend(w, r)
+	}, func(p *Proxy) error {
+		p.code.Origin = OriginPolicy{Allow: []string{"https://ide.example.com"}}
+		return nil
+	})
+	defer cleanup()
+
+	// The code-server is not dialed for rejected origins
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL+"/project1/", http.Header{"Origin": {"https://evil.example.org"}})
+	require.Error(t, err)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	require.False(t, dialed)
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"/project1/", http.Header{"Origin": {"https://ide.example.com"}})
+	require.NoError(t, err)
+	conn.Close()
+}
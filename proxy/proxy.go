@@ -1,23 +1,26 @@
 package proxy
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
-	"path"
+	"os"
 	"path/filepath"
 	"strconv"
-	"strings"
+	"sync"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 
 	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
 
-	"github.com/armon/go-radix"
 	"github.com/code-server-proxy/healthproto"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
@@ -26,25 +29,83 @@ import (
 // Proxy is a code-server proxy
 type Proxy struct {
 	*mux.Router
-	client      *http.Client
-	upgrader    websocket.Upgrader
-	code        Code
-	portMap     *radix.Tree
-	logger      *logrus.Logger
-	config      string
-	aliasToPath map[string]string
+	client     *http.Client
+	dialer     *websocket.Dialer
+	tlsConfig  *tls.Config
+	upgrader   websocket.Upgrader
+	code       Code
+	routes     *RouteTable
+	conflicts  []RouteConflict
+	resolvers  []Resolver
+	baseDomain string
+	affinity   affinity
+	unmatched  Unmatched
+	body       BodyRewrite
+	websocket  Websocket
+	origin     *originChecker
+	trusted    trustedProxies
+	counters   websocketCounters
+	sessions   sessionRegistry
+	capturer   *capturer
+	access     *accessLogger
+	exporter   Exporter
+	tracer     *tracer
+	traceFile  io.Closer
+	metrics    *metrics
+	logger     *logrus.Logger
+	config     string
+	writer     configWriter
+
+	// mu guards code, routes and conflicts
+	mu sync.RWMutex
 }
 
 // Server represents a code-server
 type Server struct {
-	Path  string
-	Alias string
-	Port  int
+	Path  string `json:"path"`
+	Alias string `json:"alias"`
+	Port  int    `json:"port"`
+	// Rewrite rules of the request path, DefaultRewrites if empty
+	Rewrite []RewriteRule `json:"rewrite,omitempty" yaml:"rewrite,omitempty"`
+	// Scheme is http or https, websockets use ws or wss accordingly
+	Scheme string `json:"scheme,omitempty" yaml:"scheme,omitempty"`
 }
 
 // Code represents the code-server structures
 type Code struct {
-	Servers []Server
+	Servers   []Server
+	Routing   Routing   `yaml:"routing,omitempty"`
+	Websocket Websocket `yaml:"websocket,omitempty"`
+	// Origin is the origin policy of websockets and registry changes
+	Origin OriginPolicy `yaml:"origin,omitempty"`
+	// AccessLog configures the log of every request served
+	AccessLog AccessLog `yaml:"accessLog,omitempty"`
+	// Tracing configures the export of spans
+	Tracing Tracing `yaml:"tracing,omitempty"`
+	// TrustedProxies are the IPs or CIDRs of proxies in front, whose
+	// X-Forwarded-For and user headers are trusted
+	TrustedProxies []string `yaml:"trustedProxies,omitempty"`
+}
+
+// Routing configures how requests are routed to code-servers
+type Routing struct {
+	// Resolvers is the chain of resolvers tried in order, DefaultResolvers if empty
+	Resolvers []string `yaml:"resolvers,omitempty"`
+	// Header is the request header of the header resolver
+	Header string `yaml:"header,omitempty"`
+	// Cookie is the cookie of the cookie resolver
+	Cookie string `yaml:"cookie,omitempty"`
+	// BaseDomain enables the host resolver for {alias}.{BaseDomain}, it
+	// may be given as the wildcard *.{BaseDomain}
+	BaseDomain string `yaml:"baseDomain,omitempty"`
+	// AffinityCookie is the signed cookie of the affinity resolver
+	AffinityCookie string `yaml:"affinityCookie,omitempty"`
+	// AffinitySecret signs affinity cookies, a random one is generated if empty
+	AffinitySecret string `yaml:"affinitySecret,omitempty"`
+	// Unmatched is the policy for requests no resolver matches
+	Unmatched Unmatched `yaml:"unmatched,omitempty"`
+	// Body configures the rewriting of response bodies
+	Body BodyRewrite `yaml:"body,omitempty"`
 }
 
 // CodeServerStatus represents the health status of a code-server
@@ -98,6 +159,32 @@ func UseUpgrader(upgrader websocket.Upgrader) func(*Proxy) error {
 	}
 }
 
+// UseBackendTLS sets the TLS config of connections to https and wss
+// code-servers
+func UseBackendTLS(config *tls.Config) func(*Proxy) error {
+	return func(p *Proxy) error {
+		p.tlsConfig = config
+		return nil
+	}
+}
+
+// UseResolvers sets the resolver chain, overriding the one configured in code
+func UseResolvers(resolvers ...Resolver) func(*Proxy) error {
+	return func(p *Proxy) error {
+		p.resolvers = resolvers
+		return nil
+	}
+}
+
+// UseAffinitySecret sets the secret of affinity cookies, overriding the one
+// configured in code
+func UseAffinitySecret(secret string) func(*Proxy) error {
+	return func(p *Proxy) error {
+		p.affinity.secret = []byte(secret)
+		return nil
+	}
+}
+
 // UseCode sets the code-server configs
 func UseCode(code Code) func(*Proxy) error {
 	return func(p *Proxy) error {
@@ -106,30 +193,135 @@ func UseCode(code Code) func(*Proxy) error {
 	}
 }
 
-// NewProxy creates a code-server proxy
+// NewProxy creates a code-server proxy. It logs to the standard logger of
+// logrus unless UseLogger is given.
 func NewProxy(options ...func(*Proxy) error) (*Proxy, error) {
-	p := &Proxy{}
+	p := &Proxy{metrics: newMetrics(), logger: logrus.StandardLogger()}
 	for _, f := range options {
 		if err := f(p); err != nil {
 			return nil, err
 		}
 	}
 
-	// Setup client
-	p.client = &http.Client{}
+	// Setup client, redirects are passed on to browsers
+	p.client = &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: p.tlsConfig,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
 
-	// Construct radix tree
-	p.portMap = radix.New()
-	for _, s := range p.code.Servers {
-		p.portMap.Insert(s.Path, s.Port)
-		p.portMap.Insert(path.Dir(s.Path), s.Port)
-		p.portMap.Insert(fmt.Sprintf("/%s", s.Alias), s.Port)
+	// Setup websocket dialer
+	dialer := *websocket.DefaultDialer
+	dialer.TLSClientConfig = p.tlsConfig
+	dialer.NetDial = p.countingDial
+	p.dialer = &dialer
+
+	// Construct resolver chain
+	routing := p.code.Routing
+	if len(p.affinity.secret) > 0 {
+		routing.AffinitySecret = string(p.affinity.secret)
 	}
+	if routing.AffinitySecret == "" {
+		secret, err := randomSecret()
+		if err != nil {
+			return nil, err
+		}
+		routing.AffinitySecret = secret
+		p.logger.Warn("No affinity secret configured, affinity cookies are reset on restart")
+	}
+
+	affinity, err := newAffinity(routing)
+	if err != nil {
+		return nil, err
+	}
+	p.affinity = affinity
 
-	// Create path to its alias mapping
-	p.aliasToPath = make(map[string]string)
+	p.baseDomain = normalizeDomain(routing.BaseDomain)
+	if p.resolvers == nil {
+		resolvers, err := newResolvers(routing)
+		if err != nil {
+			return nil, err
+		}
+		p.resolvers = resolvers
+	}
+	if p.baseDomain != "" {
+		p.resolvers = withHostResolver(p.resolvers, p.baseDomain)
+	}
+
+	// Construct routing table
 	for _, s := range p.code.Servers {
-		p.aliasToPath[s.Alias] = s.Path
+		if _, err := compileRewrites(s.Rewrite); err != nil {
+			return nil, fmt.Errorf("Invalid rewrite rules of %s: %v", s.Alias, err)
+		}
+	}
+	p.index()
+	if err := routeErrors(p.conflicts); err != nil {
+		return nil, err
+	}
+	p.logRouteConflicts(p.conflicts)
+
+	// Validate unmatched policy
+	if err := validateUnmatched(routing.Unmatched, p.routes); err != nil {
+		return nil, err
+	}
+	p.unmatched = routing.Unmatched
+	p.body = routing.Body
+	p.websocket = p.code.Websocket
+
+	// Apply the origin policy, unless the upgrader checks origins itself
+	if p.origin, err = newOriginChecker(p.code.Origin, p.baseDomain); err != nil {
+		return nil, err
+	}
+	if p.upgrader.CheckOrigin == nil {
+		p.upgrader.CheckOrigin = p.origin.allows
+	}
+
+	if p.trusted, err = parseTrustedProxies(p.code.TrustedProxies); err != nil {
+		return nil, err
+	}
+
+	switch p.websocket.Mode {
+	case "", ModeFrame, ModeRaw:
+	default:
+		return nil, fmt.Errorf("Unknown websocket mode: %s", p.websocket.Mode)
+	}
+
+	if err := validateLimits(p.websocket.Limits); err != nil {
+		return nil, err
+	}
+
+	// Negotiate compression of websocket sessions
+	if err := validateCompression(p.websocket.Compression); err != nil {
+		return nil, err
+	}
+	p.upgrader.EnableCompression = p.websocket.Compression.Frontend
+	p.dialer.EnableCompression = p.websocket.Compression.Backend
+
+	// Open the capture file of websocket frames
+	if p.capturer, err = newCapturer(p.websocket.Capture, p.logger); err != nil {
+		return nil, fmt.Errorf("Failed to open websocket capture: %v", err)
+	}
+
+	// Open the sink of the access log
+	if p.access, err = newAccessLogger(p.code.AccessLog, p.logger); err != nil {
+		p.capturer.close()
+		return nil, fmt.Errorf("Failed to open access log: %v", err)
+	}
+
+	// Open the exporter of spans, unless one is given
+	if p.exporter == nil {
+		if p.exporter, p.traceFile, err = newExporter(p.code.Tracing); err != nil {
+			p.capturer.close()
+			p.access.close()
+			return nil, fmt.Errorf("Failed to open trace exporter: %v", err)
+		}
+	}
+	if p.exporter != nil {
+		p.tracer = &tracer{exporter: p.exporter, logger: p.logger}
 	}
 
 	p.Router = mux.NewRouter()
@@ -138,32 +330,126 @@ func NewProxy(options ...func(*Proxy) error) (*Proxy, error) {
 	return p, nil
 }
 
+// Close releases the files opened by the proxy
+func (p *Proxy) Close() error {
+	err := p.capturer.close()
+	if aerr := p.access.close(); aerr != nil {
+		err = aerr
+	}
+	if p.traceFile != nil {
+		if terr := p.traceFile.Close(); terr != nil {
+			err = terr
+		}
+	}
+	return err
+}
+
+// ServeHTTP serves r. With the access log or tracing enabled, requests keep
+// the X-Request-Id of a proxy in front, or get a new one, which is passed on
+// to the code-server and the browser.
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if p.access == nil && p.tracer == nil {
+		p.Router.ServeHTTP(w, r)
+		return
+	}
+
+	start := time.Now()
+	id := r.Header.Get(requestIDHeader)
+	if id == "" {
+		id = newRequestID()
+		r.Header.Set(requestIDHeader, id)
+	}
+
+	rec := &accessRecorder{ResponseWriter: w, entry: AccessEntry{
+		Time:      start,
+		RequestID: id,
+		ClientIP:  p.trusted.clientIP(r),
+		User:      p.trusted.requestUser(r),
+		Method:    r.Method,
+		URI:       r.RequestURI,
+		Proto:     r.Proto,
+		Referer:   r.Referer(),
+		UserAgent: r.UserAgent(),
+	}}
+
+	// Requests continue the trace of the browser or a proxy in front
+	parent, remote := ParseTraceParent(r.Header.Get(traceParentHeader))
+	sp := p.tracer.start(fmt.Sprintf("HTTP %s", r.Method), SpanServer, parent, remote)
+	sp.set("http.method", r.Method)
+	sp.set("http.host", r.Host)
+	sp.set("http.target", r.RequestURI)
+	sp.set("request.id", id)
+
+	ctx := context.WithValue(r.Context(), accessRecorderKey{}, rec)
+	ctx = context.WithValue(ctx, spanKey{}, sp)
+	p.Router.ServeHTTP(rec, r.WithContext(ctx))
+
+	rec.finish(start)
+	sp.set("http.status_code", rec.entry.Status)
+	if rec.entry.Server != "" {
+		sp.set("server", rec.entry.Server)
+	}
+	if rec.entry.Status >= http.StatusInternalServerError {
+		sp.fail(fmt.Errorf("%d %s", rec.entry.Status, http.StatusText(rec.entry.Status)))
+	}
+	sp.end()
+
+	p.access.log(rec.entry)
+}
+
 func (p *Proxy) route() {
+	// Projects on subdomains own every path of their host
+	if p.baseDomain != "" {
+		subdomain := p.MatcherFunc(p.matchSubdomain).Subrouter()
+		subdomain.HandleFunc("/{filePath:.*}", p.websocketHandler).MatcherFunc(matchWebsocket)
+		subdomain.HandleFunc("/{filePath:.*}", p.forwardRequestHandler)
+	}
+
 	p.HandleFunc("/", p.healthCheckHandler)
 	p.HandleFunc("/status/{name}", p.codeServerStatusHandler).Methods("GET")
 	p.HandleFunc("/status", p.statusHandler).Methods("GET")
 
-	p.HandleFunc("/register", p.registerHandler).Methods("POST")
-	p.HandleFunc("/remove/{name}", p.removeHandler).Methods("DELETE")
+	p.HandleFunc("/register", p.requireOrigin(p.registerHandler)).Methods("POST")
+	p.HandleFunc("/remove/{name}", p.requireOrigin(p.removeHandler)).Methods("DELETE")
+
+	p.HandleFunc("/metrics", p.metricsHandler).Methods("GET")
+
+	p.HandleFunc("/sessions", p.listSessionsHandler).Methods("GET")
+	p.HandleFunc("/sessions/{id}", p.requireOrigin(p.closeSessionHandler)).Methods("DELETE")
+
+	p.routeAPI()
+
+	p.HandleFunc("/debug/routes", p.debugRoutesHandler).Methods("GET")
+	p.HandleFunc("/debug/resolve", p.debugResolveHandler).Methods("GET")
+	p.HandleFunc("/debug/websocket", p.debugWebsocketHandler).Methods("GET")
 
 	// The sequence of following two rules can not exchange
-	p.HandleFunc("/{filePath:.*}", p.websocketHandler).Headers("Connection", "upgrade")
+	p.HandleFunc("/{filePath:.*}", p.websocketHandler).MatcherFunc(matchWebsocket)
 
 	p.HandleFunc("/{filePath:.*}", p.forwardRequestHandler)
 }
 
+// matchSubdomain matches requests for the subdomain of a code-server
+func (p *Proxy) matchSubdomain(r *http.Request, _ *mux.RouteMatch) bool {
+	p.mu.RLock()
+	routes := p.routes
+	p.mu.RUnlock()
+
+	_, ok := hostResolver{baseDomain: p.baseDomain}.Resolve(r, routes)
+	return ok
+}
+
 // healthCheckHandler handles healthcheck request
 func (p *Proxy) healthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	healthcheckResponse := HealthcheckResponse{}
 
-	for _, s := range p.code.Servers {
-		state, err := p.checkCodeServerStatus(s.Port)
+	for _, s := range p.servers() {
+		state, err := p.probe(s)
 		if err != nil {
 			p.logger.Errorf("Failed to check code-server status: %v", err)
 		}
 
 		backendURL := url.URL{Scheme: "https", Host: r.Host, Path: s.Path}
-		aliasURL := url.URL{Scheme: "https", Host: r.Host, Path: s.Alias}
 		healthcheckResponse.CodeServers = append(
 			healthcheckResponse.CodeServers,
 			CodeServerStatus{
@@ -171,7 +457,7 @@ func (p *Proxy) healthCheckHandler(w http.ResponseWriter, r *http.Request) {
 				State:    state,
 				URL:      backendURL.String(),
 				Alias:    s.Alias,
-				AliasURL: aliasURL.String(),
+				AliasURL: p.aliasURL(r.Host, s.Alias),
 			},
 		)
 	}
@@ -191,55 +477,85 @@ func (p *Proxy) healthCheckHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (p *Proxy) statusHandler(w http.ResponseWriter, r *http.Request) {
+	healthCheck := p.healthCheck(r.Host)
+
+	b, merr := proto.Marshal(healthCheck)
+	if merr != nil {
+		p.logger.Errorf("Failed to marshal healthcheck object: %v", merr)
+	}
+
+	if _, werr := w.Write(b); werr != nil {
+		http.Error(w, werr.Error(), http.StatusInternalServerError)
+	}
+}
+
+// healthCheck checks the status of all code-servers. URLs are only reported
+// if the host of code-server-proxy or the base domain is known.
+func (p *Proxy) healthCheck(host string) *healthproto.HealthCheck {
 	healthCheck := healthproto.HealthCheck{}
 	healthCheck.CodeServerProxy = "OK"
 
-	for _, s := range p.code.Servers {
-		state, err := p.checkCodeServerStatus(s.Port)
+	for _, s := range p.servers() {
+		state, err := p.probe(s)
 		if err != nil {
 			p.logger.Errorf("Failed to check code-server status: %v", err)
 		}
 
-		backendURL := url.URL{Scheme: "https", Host: r.Host, Path: s.Path}
-		aliasURL := url.URL{Scheme: "https", Host: r.Host, Path: s.Alias}
-		healthCheck.CodeServers = append(
-			healthCheck.CodeServers,
-			&healthproto.CodeServerStatus{
-				Port:     int64(s.Port),
-				State:    state,
-				Url:      backendURL.String(),
-				Alias:    s.Alias,
-				AliasURL: aliasURL.String(),
-			},
-		)
+		status := &healthproto.CodeServerStatus{
+			Port:  int64(s.Port),
+			State: state,
+			Alias: s.Alias,
+		}
+
+		if host != "" {
+			backendURL := url.URL{Scheme: "https", Host: host, Path: s.Path}
+			status.Url = backendURL.String()
+		}
+		status.AliasURL = p.aliasURL(host, s.Alias)
+
+		healthCheck.CodeServers = append(healthCheck.CodeServers, status)
 	}
 
-	b, merr := proto.Marshal(&healthCheck)
-	if merr != nil {
-		p.logger.Errorf("Failed to marshal healthcheck object: %v", merr)
+	return &healthCheck
+}
+
+// aliasURL returns the URL of the code-server registered under alias, on
+// its subdomain if a base domain is configured
+func (p *Proxy) aliasURL(host, alias string) string {
+	if p.baseDomain != "" {
+		u := url.URL{Scheme: "https", Host: fmt.Sprintf("%s.%s", alias, p.baseDomain), Path: "/"}
+		return u.String()
 	}
 
-	if _, werr := w.Write(b); werr != nil {
-		http.Error(w, werr.Error(), http.StatusInternalServerError)
+	if host == "" {
+		return ""
 	}
+
+	u := url.URL{Scheme: "https", Host: host, Path: alias}
+	return u.String()
 }
 
 func (p *Proxy) codeServerStatusHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	name := fmt.Sprintf("/%s", vars["name"])
-	port, ok := p.portMap.Get(name)
+
+	p.mu.RLock()
+	route, ok := p.routes.Get(name)
+	s, _ := p.routes.Server(route.Alias)
+	p.mu.RUnlock()
 	if !ok {
 		http.Error(w, fmt.Sprintf("Project %s does not exist", vars["name"]), http.StatusBadRequest)
 		return
 	}
 
-	state, err := p.checkCodeServerStatus(port.(int))
+	port := route.Port
+	state, err := p.probe(s)
 	if err != nil {
 		p.logger.Errorf("Failed to check code-server status: %v", err)
 	}
 
 	codeServerStatus := healthproto.CodeServerStatus{
-		Port:  int64(port.(int)),
+		Port:  int64(port),
 		State: state,
 	}
 
@@ -254,159 +570,178 @@ func (p *Proxy) codeServerStatusHandler(w http.ResponseWriter, r *http.Request)
 }
 
 func (p *Proxy) forwardRequestHandler(w http.ResponseWriter, r *http.Request) {
-	host := fmt.Sprintf("localhost:%d", p.getPort(r))
-	cleanedPath := p.cleanRequestPath(r.RequestURI)
-	backendHTTPURL := url.URL{
-		Scheme: "http",
-		Host:   host,
-		Path:   cleanedPath,
+	res, err := p.resolveRequest(r)
+	if err != nil {
+		p.unmatchedHandler(w, r, err)
+		return
 	}
 
-	req, err := http.NewRequest(r.Method, backendHTTPURL.String(), nil)
+	start := time.Now()
+	status := http.StatusInternalServerError
+	defer func() {
+		p.metrics.observeRequest(res.Server.Alias, status, time.Since(start))
+	}()
+
+	req, err := http.NewRequest(r.Method, res.BackendURL, nil)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	req.Header = r.Header
+	req.Header = forwardHeader(r, res, p.body.Enabled)
+
+	sp := p.startSpan(r, fmt.Sprintf("backend %s", r.Method), SpanClient)
+	sp.set("http.url", res.BackendURL)
+	sp.set("server", res.Server.Alias)
+	sp.inject(req.Header)
 
 	resp, err := p.client.Do(req)
 	if err != nil {
+		sp.fail(err)
+		sp.end()
+		p.metrics.backendError(res.Server.Alias)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	sp.set("http.status_code", resp.StatusCode)
+	sp.end()
+	defer resp.Body.Close()
 
 	p.logger.WithFields(logrus.Fields{
-		"host":          host,
-		"path":          cleanedPath,
-		"backend":       backendHTTPURL.String(),
+		"host":          req.URL.Host,
+		"path":          req.URL.Path,
+		"backend":       res.BackendURL,
+		"rule":          res.Rule,
+		"server":        res.Server.Alias,
 		"response-code": resp.StatusCode,
 		"request-url":   resp.Request.URL,
 		"referer":       r.Referer(),
 	}).Info("Receive forward request")
 
+	var body io.Reader = resp.Body
+	if res.ProjectPrefix != "" && hasBody(r, resp) && p.body.rewrites(resp.Header.Get("Content-Type")) && resp.Header.Get("Content-Encoding") == "" {
+		b, rerr := ioutil.ReadAll(resp.Body)
+		if rerr != nil {
+			status = http.StatusBadGateway
+			http.Error(w, rerr.Error(), http.StatusBadGateway)
+			return
+		}
+
+		b = rewriteBody(b, resp.Header.Get("Content-Type"), res.ProjectPrefix, pageDir(r, res))
+		resp.Header.Set("Content-Length", strconv.Itoa(len(b)))
+		body = bytes.NewReader(b)
+	}
+
+	rewriteResponseHeader(resp.Header, r, res)
 	for h, vals := range resp.Header {
 		for _, v := range vals {
 			w.Header().Add(h, v)
 		}
 	}
+	p.setAffinity(w, r, res)
+	status = resp.StatusCode
+	w.WriteHeader(resp.StatusCode)
 
-	if _, cerr := io.Copy(w, resp.Body); cerr != nil {
+	if _, cerr := io.Copy(w, body); cerr != nil {
 		http.Error(w, cerr.Error(), http.StatusInternalServerError)
 		return
 	}
 }
 
+// registerHandler is the legacy shim of POST /api/v1/servers
 func (p *Proxy) registerHandler(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 
 	var data RegisterRequest
 
 	if err := decoder.Decode(&data); err != nil {
-		p.logger.Errorf("Failed to decode: %v", err)
+		p.writeAPIError(w, r, &APIError{
+			Status:  http.StatusBadRequest,
+			Code:    ErrCodeInvalidRequest,
+			Message: fmt.Sprintf("Failed to decode request body: %v", err),
+		})
 		return
 	}
 
 	port, err := strconv.Atoi(data.Port)
 	if err != nil {
-		p.logger.Errorf("Failed to convert port to integer: %v", err)
+		verr := &ValidationError{}
+		verr.add("port", "must be an integer")
+		p.writeAPIError(w, r, registryError(verr, Server{}))
 		return
 	}
 
-	// Prevent duplicated alias or port
-	for _, server := range p.code.Servers {
-		if server.Alias == data.Name {
-			http.Error(w, fmt.Sprintf("Name %s is in use", data.Name), http.StatusBadRequest)
-			return
-		}
-
-		if server.Port == port {
-			http.Error(w, fmt.Sprintf("Name %s is in use", data.Name), http.StatusBadRequest)
-			return
-		}
-	}
-
-	// Append the new code-server to existing slice
-	p.code.Servers = append(p.code.Servers, Server{
+	s := Server{
 		Path:  data.Folder,
 		Alias: data.Name,
 		Port:  port,
-	})
-
-	// Consolidate the new code object async
-	go WriteConfig(p.code, p.config)
+	}
 
-	// Store new path and port to radix tree
-	p.portMap.Insert(data.Folder, port)
-	p.portMap.Insert(path.Dir(data.Folder), port)
-	p.portMap.Insert(fmt.Sprintf("/%s", data.Name), port)
+	if err := p.addServer(s); err != nil {
+		// Report invalid fields by their names in RegisterRequest
+		if verr, ok := err.(*ValidationError); ok {
+			for i, f := range verr.Fields {
+				switch f.Field {
+				case "path":
+					verr.Fields[i].Field = "folder"
+				case "alias":
+					verr.Fields[i].Field = "name"
+				}
+			}
+		}
 
-	// Store new alias and name to map
-	p.aliasToPath[data.Name] = data.Folder
+		p.writeAPIError(w, r, registryError(err, s))
+		return
+	}
 
 	w.WriteHeader(http.StatusOK)
 }
 
+// removeHandler is the legacy shim of DELETE /api/v1/servers/{name}
 func (p *Proxy) removeHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	name := vars["name"]
-
-	if _, ok := p.aliasToPath[name]; !ok {
-		http.Error(w, fmt.Sprintf("Code-server %s doesn't exist", name), http.StatusBadRequest)
-		return
-	}
-
-	for i, server := range p.code.Servers {
-		if server.Alias == name {
-			p.code.Servers = append(p.code.Servers[:i], p.code.Servers[i+1:]...)
-			break
-		}
-	}
-
-	p.portMap.Delete(fmt.Sprintf("/%s", name))
-	delete(p.aliasToPath, name)
-
-	w.WriteHeader(http.StatusNoContent)
+	p.deleteServerHandler(w, r)
 }
 
-// cleanRequestPath removes unrelated prefix from request path
-func (p *Proxy) cleanRequestPath(requestPath string) string {
-	prefix, _, _ := p.portMap.LongestPrefix(requestPath)
-	requestPath = strings.TrimPrefix(requestPath, prefix)
-
-	if strings.HasPrefix(requestPath, "/login") {
-		requestPath = "/login" + requestPath
-	}
-
-	return requestPath
+// probeTimeout is how long a code-server is given to answer a probe
+const probeTimeout = 5 * time.Second
+
+// probe checks the status of the code-server s, recording the result in the
+// metrics and a span
+func (p *Proxy) probe(s Server) (string, error) {
+	sp := p.tracer.start("probe", SpanClient, SpanContext{}, false)
+	defer sp.end()
+
+	start := time.Now()
+	state, err := p.checkCodeServerStatus(s)
+	result := probeResult(state, err)
+	p.metrics.observeProbe(s.Alias, result, time.Since(start))
+
+	sp.set("server", s.Alias)
+	sp.set("port", s.Port)
+	sp.set("probe.result", result)
+	sp.fail(err)
+	return state, err
 }
 
-// getPort returns the port corresponding to the path.
-func (p *Proxy) getPort(r *http.Request) int {
-	requestPath := r.RequestURI
-	if r.Referer() != "" {
-		u, err := url.Parse(r.Referer())
-		if err != nil {
-			p.logger.Fatalf("Failed to parse referer: %v", err)
-		}
-		requestPath = u.Path
-	}
+// checkCodeServerStatus checks status of code-server s
+func (p *Proxy) checkCodeServerStatus(s Server) (string, error) {
+	state := "NOT OK"
 
-	port := p.code.Servers[0].Port
-	if _, val, ok := p.portMap.LongestPrefix(requestPath); ok {
-		port = val.(int)
+	pingURL := serverURL(s, "/ping")
+	req, err := http.NewRequest("GET", pingURL.String(), nil)
+	if err != nil {
+		return "", err
 	}
-	return port
-}
-
-// checkCodeServerStatus checks status of code-server by port
-func (p *Proxy) checkCodeServerStatus(port int) (string, error) {
-	state := "NOT OK"
-	resp, err := http.Get(fmt.Sprintf("http://localhost:%d/ping", port))
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
 
-	if err == nil && resp.StatusCode == http.StatusOK {
-		defer resp.Body.Close()
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return state, nil
+	}
+	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusOK {
 		codeServerPingResponse := CodeServerPingResponse{}
 		b, err := ioutil.ReadAll(resp.Body)
 
@@ -447,8 +782,25 @@ func WriteConfig(c Code, config string) error {
 		return err
 	}
 
-	if werr := ioutil.WriteFile(filepath.Clean(config), y, 0644); err != nil {
-		return werr
+	// Write a temporary file next to the config and rename it over the
+	// config, so that readers never see a partial config
+	config = filepath.Clean(config)
+	f, err := ioutil.TempFile(filepath.Dir(config), filepath.Base(config)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(y); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
 	}
-	return nil
+	return os.Rename(f.Name(), config)
 }
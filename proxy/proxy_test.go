@@ -4,9 +4,14 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
 	"path"
+	"path/filepath"
+	"strconv"
 	"testing"
 
 	"github.com/code-server-proxy/healthproto"
@@ -35,6 +40,32 @@ func newTestProxy() (*Proxy, error) {
 	return p, nil
 }
 
+// newTestFolder creates a temporary directory holding the project folders
+// coolproj and other.
+func newTestFolder(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "code-server-proxy")
+	require.NoError(t, err)
+
+	for _, name := range []string{"coolproj", "other"} {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, name), 0755))
+	}
+
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// newTestBackend starts a code-server stub serving handler, and returns
+// its port
+func newTestBackend(t testing.TB, handler http.HandlerFunc) (int, func()) {
+	backend := httptest.NewServer(handler)
+
+	u, err := url.Parse(backend.URL)
+	require.NoError(t, err)
+	port, err := strconv.Atoi(u.Port())
+	require.NoError(t, err)
+
+	return port, backend.Close
+}
+
 func TestCleanRequestPath(t *testing.T) {
 	p, err := newTestProxy()
 	require.NoError(t, err)
@@ -50,7 +81,9 @@ func TestCleanRequestPath(t *testing.T) {
 	}
 
 	for _, path := range paths {
-		cleanedPath = p.cleanRequestPath(path)
+		res, err := p.resolve(&http.Request{RequestURI: path, Header: http.Header{}})
+		require.NoError(t, err)
+		cleanedPath = res.backendPath
 		require.Equal(t, expectedPath, cleanedPath)
 	}
 }
@@ -93,7 +126,9 @@ func TestPathToPort(t *testing.T) {
 	}
 
 	for _, d := range data {
-		require.Equal(t, d.port, p.getPort(d.r))
+		res, err := p.resolve(d.r)
+		require.NoError(t, err)
+		require.Equal(t, d.port, res.Server.Port)
 	}
 }
 
@@ -149,8 +184,11 @@ func TestRegisterHandler(t *testing.T) {
 	p, err := newTestProxy()
 	require.NoError(t, err)
 
+	dir, cleanup := newTestFolder(t)
+	defer cleanup()
+
 	reqBody := RegisterRequest{
-		Folder: "/k/m/n",
+		Folder: filepath.Join(dir, "coolproj"),
 		Name:   "coolproj",
 		Port:   "1999",
 	}
@@ -169,24 +207,57 @@ func TestRegisterHandler(t *testing.T) {
 	require.Equal(t, rr.Code, http.StatusOK, "incorrect response code")
 
 	var ok bool
-	_, ok = p.portMap.Get(fmt.Sprintf("/%s", reqBody.Name))
+	_, ok = p.routes.Get(fmt.Sprintf("/%s", reqBody.Name))
 	require.True(t, ok, "alias not found in radix tree")
 
-	_, ok = p.portMap.Get(reqBody.Folder)
+	_, ok = p.routes.Get(reqBody.Folder)
 	require.True(t, ok, "path not found in radix tree")
 
-	_, ok = p.portMap.Get(path.Dir(reqBody.Folder))
+	_, ok = p.routes.Get(path.Dir(reqBody.Folder))
 	require.True(t, ok, "parent path not found in radix tree")
 }
 
+func TestNewProxyWithoutLogger(t *testing.T) {
+	p, err := NewProxy(UseCode(Code{Servers: []Server{{Path: "/a/b/c", Alias: "project1", Port: 9000}}}))
+	require.NoError(t, err)
+	require.Equal(t, logrus.StandardLogger(), p.logger)
+}
+
 func TestCheckCodeServerStatus(t *testing.T) {
 	port := 9999
 	expectedState := "NOT OK"
 	p, err := newTestProxy()
 	require.NoError(t, err)
 
-	state, err := p.checkCodeServerStatus(port)
+	state, err := p.checkCodeServerStatus(Server{Port: port})
 	require.NoError(t, err)
 
 	require.Equal(t, expectedState, state)
 }
+
+func TestCheckCodeServerStatusTLS(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"hostname":"ide"}`)
+	}))
+	defer backend.Close()
+
+	u, err := url.Parse(backend.URL)
+	require.NoError(t, err)
+	port, err := strconv.Atoi(u.Port())
+	require.NoError(t, err)
+
+	// The certificate of the backend is issued for example.com
+	config := backend.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	config.ServerName = "example.com"
+
+	p, err := NewProxy(UseLogger(logrus.New()), UseBackendTLS(config))
+	require.NoError(t, err)
+
+	state, err := p.checkCodeServerStatus(Server{Port: port, Scheme: SchemeHTTPS})
+	require.NoError(t, err)
+	require.Equal(t, "OK", state)
+
+	state, err = p.checkCodeServerStatus(Server{Port: port})
+	require.NoError(t, err)
+	require.Equal(t, "NOT OK", state)
+}
@@ -0,0 +1,287 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Modes of websocket sessions
+const (
+	// ModeFrame tunnels sessions message by message
+	ModeFrame = "frame"
+	// ModeRaw copies the bytes of sessions after the handshake
+	ModeRaw = "raw"
+)
+
+// rawChunkSize is the amount of bytes copied at once in raw mode, between
+// which sessions are accounted
+const rawChunkSize = 64 << 10
+
+// websocketGUID is the key of Sec-WebSocket-Accept of RFC 6455
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// acceptKey returns the Sec-WebSocket-Accept of the Sec-WebSocket-Key key
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// rawWebsocketHandler forwards the handshake of r to the code-server of
+// res and, once it is accepted, copies the bytes of both connections as
+// they are. Extensions and subprotocols are negotiated between browser and
+// code-server directly. On Linux, bytes between TCP connections are copied
+// by splice(2) without passing user space.
+func (p *Proxy) rawWebsocketHandler(w http.ResponseWriter, r *http.Request, res Resolution) {
+	key := r.Header.Get("Sec-Websocket-Key")
+	if key == "" || r.Header.Get("Sec-Websocket-Version") != "13" {
+		http.Error(w, "Unsupported websocket handshake", http.StatusBadRequest)
+		return
+	}
+
+	backendURL, err := url.Parse(res.BackendURL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	dial := p.startSpan(r, "websocket dial", SpanClient)
+	dial.set("http.url", res.BackendURL)
+	dial.set("server", res.Server.Alias)
+
+	back, err := p.dialRaw(backendURL)
+	if err != nil {
+		dial.fail(err)
+		dial.end()
+		p.metrics.backendError(res.Server.Alias)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer back.Close()
+
+	// Forward the handshake within the handshake timeout
+	back.SetDeadline(time.Now().Add(p.dialer.HandshakeTimeout))
+
+	header := copyHeader(forwardHeader(r, res, false), []string{"Content-Length"})
+	header.Set("Connection", "Upgrade")
+	header.Set("Upgrade", "websocket")
+	dial.inject(header)
+
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        backendURL,
+		Host:       backendURL.Host,
+		Header:     header,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+	}
+	if err := req.Write(back); err != nil {
+		dial.fail(err)
+		dial.end()
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	backReader := bufio.NewReader(back)
+	resp, err := http.ReadResponse(backReader, req)
+	if err != nil {
+		dial.fail(err)
+		dial.end()
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	dial.set("http.status_code", resp.StatusCode)
+	dial.end()
+
+	respHeader := copyHeader(resp.Header, hopHeaders)
+	rewriteResponseHeader(respHeader, r, res)
+
+	// Pass refused handshakes on, e.g. for authentication
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		defer resp.Body.Close()
+		for h, vals := range respHeader {
+			w.Header()[h] = vals
+		}
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		return
+	}
+
+	if !strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") || resp.Header.Get("Sec-Websocket-Accept") != acceptKey(key) {
+		http.Error(w, "Invalid websocket handshake of code-server", http.StatusBadGateway)
+		return
+	}
+	back.SetDeadline(time.Time{})
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Websocket connections can not be hijacked", http.StatusInternalServerError)
+		return
+	}
+	front, frontRW, err := hj.Hijack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer front.Close()
+
+	respHeader.Set("Connection", "Upgrade")
+	respHeader.Set("Upgrade", "websocket")
+	frontRW.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	respHeader.Write(frontRW)
+	frontRW.WriteString("\r\n")
+	if err := frontRW.Flush(); err != nil {
+		return
+	}
+
+	// Bytes read ahead by either side are sent first
+	frontBuffered, _ := frontRW.Reader.Peek(frontRW.Reader.Buffered())
+	backBuffered, _ := backReader.Peek(backReader.Buffered())
+
+	s := p.newSession(nil, nil, Session{
+		Server:   res.Server.Alias,
+		Path:     requestPath(r),
+		ClientIP: p.trusted.clientIP(r),
+		User:     p.trusted.requestUser(r),
+		Start:    time.Now(),
+	})
+	if err := p.sessions.add(s); err != nil {
+		p.logger.Error(err)
+		return
+	}
+	defer p.sessions.remove(s)
+
+	sp := p.startSpan(r, "websocket session", SpanInternal)
+	p.spliceRaw(s, rawLeg{front, frontBuffered}, rawLeg{back, backBuffered})
+	p.endSession(r, s, sp)
+}
+
+// dialRaw connects to the code-server of u, with TLS for https
+func (p *Proxy) dialRaw(u *url.URL) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", u.Host, p.dialer.HandshakeTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != SchemeHTTPS {
+		return conn, nil
+	}
+
+	config := &tls.Config{}
+	if p.tlsConfig != nil {
+		config = p.tlsConfig.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName = u.Hostname()
+	}
+
+	tlsConn := tls.Client(conn, config)
+	tlsConn.SetDeadline(time.Now().Add(p.dialer.HandshakeTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// rawLeg is a side of a raw session with the bytes read ahead of it
+type rawLeg struct {
+	conn     net.Conn
+	buffered []byte
+}
+
+// spliceRaw copies bytes between front and back until either closes its
+// connection or the session is terminated
+func (p *Proxy) spliceRaw(s *session, front, back rawLeg) {
+	results := make(chan pumpResult, 2)
+
+	go s.copyRaw(back.conn, front, &s.toBackend, sideBackend, sideFrontend, results)
+	go s.copyRaw(front.conn, back, &s.toFrontend, sideFrontend, sideBackend, results)
+
+	pending := 2
+	var reason string
+	var err error
+	select {
+	case first := <-results:
+		pending--
+		reason, err = fmt.Sprintf("%s closed the connection", first.failed.side), first.err
+	case reason = <-s.terminated:
+	}
+
+	// Closing both connections stops the other pump
+	front.conn.Close()
+	back.conn.Close()
+	for ; pending > 0; pending-- {
+		<-results
+	}
+
+	s.endReason = reason
+	p.logger.WithFields(logrus.Fields{
+		"session": s.id,
+		"server":  s.info.Server,
+		"reason":  reason,
+		"error":   err,
+	}).Info("Websocket session ended")
+}
+
+// copyRaw copies the bytes of src to dst in chunks, which are spliced if
+// both are TCP connections
+func (s *session) copyRaw(dst net.Conn, src rawLeg, counter *transferCounter, dstSide, srcSide string, results chan<- pumpResult) {
+	report := func(side string, err error) {
+		if err == io.EOF || isClosed(err) {
+			err = nil
+		}
+		results <- pumpResult{failed: leg{side: side}, err: err}
+	}
+
+	if len(src.buffered) > 0 {
+		if _, err := dst.Write(src.buffered); err != nil {
+			report(dstSide, err)
+			return
+		}
+		s.countRaw(counter, dstSide, int64(len(src.buffered)))
+	}
+
+	for {
+		n, err := io.CopyN(dst, src.conn, rawChunkSize)
+		s.countRaw(counter, dstSide, n)
+		if err != nil {
+			// CopyN can't tell which side failed, writes to dst fail on
+			// closed connections only after the other side went away
+			report(srcSide, err)
+			return
+		}
+	}
+}
+
+// countRaw adds n bytes copied by a raw session to dstSide to counter and
+// both legs
+func (s *session) countRaw(counter *transferCounter, dstSide string, n int64) {
+	if n == 0 {
+		return
+	}
+	atomic.AddInt64(&counter.bytes, n)
+	s.metrics.add(dstSide, n)
+	for _, l := range []leg{s.front, s.back} {
+		atomic.AddInt64(&l.counter.payload, n)
+		atomic.AddInt64(&l.counter.wire, n)
+	}
+	s.touch()
+}
+
+// isClosed reports whether err is the error of using a closed connection
+func isClosed(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "use of closed network connection")
+}
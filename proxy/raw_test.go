@@ -0,0 +1,143 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+var rawMode = useWebsocket(Websocket{Mode: ModeRaw})
+
+func TestRawWebsocket(t *testing.T) {
+	upgrader := websocket.Upgrader{Subprotocols: []string{"vscode"}, CheckOrigin: anyOrigin}
+	p, wsURL, cleanup := newTestWebsocketProxy(t, echo(upgrader, http.Header{"Set-Cookie": {"key=value; Path=/"}}), rawMode)
+	defer cleanup()
+
+	dialer := websocket.Dialer{Subprotocols: []string{"vscode"}}
+	conn, resp, err := dialer.Dial(wsURL+"/project1/", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.Equal(t, "vscode", conn.Subprotocol())
+	require.Equal(t, "key=value; Path=/project1", resp.Header.Get("Set-Cookie"))
+
+	for _, msg := range []string{"hello", string(bytes.Repeat([]byte("x"), 3*rawChunkSize))} {
+		require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(msg)))
+		_, echoed, err := conn.ReadMessage()
+		require.NoError(t, err)
+		require.Equal(t, msg, string(echoed))
+	}
+
+	// Bytes are accounted by chunk
+	sessions := p.sessions.list("")
+	require.Len(t, sessions, 1)
+	require.Equal(t, int64(3*rawChunkSize), sessions[0].ToBackend.Bytes)
+	require.Equal(t, int64(3*rawChunkSize), sessions[0].ToFrontend.Bytes)
+
+	// Terminated sessions drop both connections
+	s, ok := p.sessions.get(sessions[0].ID)
+	require.True(t, ok)
+	s.terminate("closed by administrator")
+
+	_, _, err = conn.ReadMessage()
+	require.Error(t, err)
+	waitSessions(t, p)
+}
+
+func TestRawWebsocketCompression(t *testing.T) {
+	upgrader := websocket.Upgrader{CheckOrigin: anyOrigin, EnableCompression: true}
+	_, wsURL, cleanup := newTestWebsocketProxy(t, echo(upgrader, nil), rawMode)
+	defer cleanup()
+
+	// Compression is negotiated with the code-server, although the proxy
+	// doesn't enable it
+	dialer := websocket.Dialer{EnableCompression: true}
+	conn, resp, err := dialer.Dial(wsURL+"/project1/", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Contains(t, resp.Header.Get("Sec-Websocket-Extensions"), "permessage-deflate")
+
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("hello")))
+	_, echoed, err := conn.ReadMessage()
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(echoed))
+}
+
+func TestRawWebsocketRefused(t *testing.T) {
+	_, wsURL, cleanup := newTestWebsocketProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", "Basic")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	}, rawMode)
+	defer cleanup()
+
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL+"/project1/", nil)
+	require.Error(t, err)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	require.Equal(t, "Basic", resp.Header.Get("WWW-Authenticate"))
+}
+
+func TestRawWebsocketOrigin(t *testing.T) {
+	_, wsURL, cleanup := newTestWebsocketProxy(t, echo(websocket.Upgrader{CheckOrigin: anyOrigin}, nil), rawMode)
+	defer cleanup()
+
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL+"/project1/", http.Header{"Origin": {"https://evil.example.com"}})
+	require.Error(t, err)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestRawWebsocketInvalidAccept(t *testing.T) {
+	_, wsURL, cleanup := newTestWebsocketProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Upgrade", "websocket")
+		w.Header().Set("Connection", "Upgrade")
+		w.Header().Set("Sec-Websocket-Accept", "invalid")
+		w.WriteHeader(http.StatusSwitchingProtocols)
+	}, rawMode)
+	defer cleanup()
+
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL+"/project1/", nil)
+	require.Error(t, err)
+	require.Equal(t, http.StatusBadGateway, resp.StatusCode)
+}
+
+func TestWebsocketMode(t *testing.T) {
+	_, err := NewProxy(UseLogger(logrus.New()), useWebsocket(Websocket{Mode: "zero-copy"}))
+	require.Error(t, err)
+}
+
+// benchmarkWebsocket echoes messages of size through a proxy in mode
+func benchmarkWebsocket(b *testing.B, mode string, size int) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	_, wsURL, cleanup := newTestWebsocketProxy(b, echo(websocket.Upgrader{CheckOrigin: anyOrigin}, nil),
+		UseLogger(logger), useWebsocket(Websocket{Mode: mode}))
+	defer cleanup()
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"/project1/", nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+
+	msg := bytes.Repeat([]byte{'x'}, size)
+	b.SetBytes(int64(2 * size))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := conn.WriteMessage(websocket.BinaryMessage, msg); err != nil {
+			b.Fatal(err)
+		}
+		if _, _, err := conn.ReadMessage(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWebsocketFrame1K(b *testing.B) { benchmarkWebsocket(b, ModeFrame, 1<<10) }
+func BenchmarkWebsocketRaw1K(b *testing.B)   { benchmarkWebsocket(b, ModeRaw, 1<<10) }
+func BenchmarkWebsocketFrame1M(b *testing.B) { benchmarkWebsocket(b, ModeFrame, 1<<20) }
+func BenchmarkWebsocketRaw1M(b *testing.B)   { benchmarkWebsocket(b, ModeRaw, 1<<20) }
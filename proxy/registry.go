@@ -0,0 +1,219 @@
+package proxy
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	// ErrServerNotFound means no code-server is registered under the alias
+	ErrServerNotFound = errors.New("code-server not found")
+	// ErrAliasInUse means another code-server is registered under the alias
+	ErrAliasInUse = errors.New("alias is in use")
+	// ErrPortInUse means another code-server listens to the port
+	ErrPortInUse = errors.New("port is in use")
+)
+
+// servers returns a snapshot of the registered code-servers
+func (p *Proxy) servers() []Server {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	servers := make([]Server, len(p.code.Servers))
+	copy(servers, p.code.Servers)
+	return servers
+}
+
+// server returns the code-server registered under alias
+func (p *Proxy) server(alias string) (Server, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for _, s := range p.code.Servers {
+		if s.Alias == alias {
+			return s, true
+		}
+	}
+	return Server{}, false
+}
+
+// addServer registers a new code-server
+func (p *Proxy) addServer(s Server) error {
+	verr := validateServer(s)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.validateOverlaps(verr, s, "")
+	if err := verr.orNil(); err != nil {
+		return err
+	}
+
+	if err := p.checkConflicts(s, ""); err != nil {
+		return err
+	}
+
+	servers := make([]Server, len(p.code.Servers), len(p.code.Servers)+1)
+	copy(servers, p.code.Servers)
+
+	return p.commit(append(servers, s), s.Alias)
+}
+
+// updateServer replaces the code-server registered under alias with s
+func (p *Proxy) updateServer(alias string, s Server) error {
+	verr := validateServer(s)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	i := p.serverIndex(alias)
+	if i < 0 {
+		return ErrServerNotFound
+	}
+
+	p.validateOverlaps(verr, s, alias)
+	if err := verr.orNil(); err != nil {
+		return err
+	}
+
+	if err := p.checkConflicts(s, alias); err != nil {
+		return err
+	}
+
+	servers := make([]Server, len(p.code.Servers))
+	copy(servers, p.code.Servers)
+	servers[i] = s
+
+	return p.commit(servers, s.Alias)
+}
+
+// removeServer unregisters the code-server registered under alias
+func (p *Proxy) removeServer(alias string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	i := p.serverIndex(alias)
+	if i < 0 {
+		return ErrServerNotFound
+	}
+
+	servers := make([]Server, 0, len(p.code.Servers)-1)
+	servers = append(servers, p.code.Servers[:i]...)
+	servers = append(servers, p.code.Servers[i+1:]...)
+
+	return p.commit(servers, alias)
+}
+
+// commit replaces the registry with servers unless their routes are
+// ambiguous. Shadowed routes of the changed server are logged.
+// The caller must hold p.mu.
+func (p *Proxy) commit(servers []Server, changed string) error {
+	_, conflicts := buildRoutes(servers)
+	if err := routeErrors(conflicts); err != nil {
+		return err
+	}
+
+	p.code.Servers = servers
+	p.index()
+
+	for _, c := range p.conflicts {
+		if c.Route.Alias == changed || c.Shadowed.Alias == changed {
+			p.logRouteConflicts([]RouteConflict{c})
+		}
+	}
+
+	p.persist()
+	return nil
+}
+
+// serverIndex returns the position of alias in the registry, or -1.
+// The caller must hold p.mu.
+func (p *Proxy) serverIndex(alias string) int {
+	for i, s := range p.code.Servers {
+		if s.Alias == alias {
+			return i
+		}
+	}
+	return -1
+}
+
+// checkConflicts prevents duplicated alias or port. The server registered
+// under self is skipped so that it can be updated in place.
+// The caller must hold p.mu.
+func (p *Proxy) checkConflicts(s Server, self string) error {
+	for _, server := range p.code.Servers {
+		if self != "" && server.Alias == self {
+			continue
+		}
+
+		if server.Alias == s.Alias {
+			return ErrAliasInUse
+		}
+
+		if server.Port == s.Port {
+			return ErrPortInUse
+		}
+	}
+	return nil
+}
+
+// index rebuilds the routing table from the registry.
+// The caller must hold p.mu.
+func (p *Proxy) index() {
+	p.routes, p.conflicts = buildRoutes(p.code.Servers)
+}
+
+// persist consolidates the registry to the config file async.
+// The caller must hold p.mu.
+func (p *Proxy) persist() {
+	if p.config == "" {
+		return
+	}
+
+	code := p.code
+	code.Servers = make([]Server, len(p.code.Servers))
+	copy(code.Servers, p.code.Servers)
+
+	p.writer.save(code, func(c Code) {
+		if err := WriteConfig(c, p.config); err != nil {
+			p.metrics.configWriteFailed()
+			p.logger.Errorf("Failed to write config: %v", err)
+		}
+	})
+}
+
+// configWriter writes snapshots of the registry one at a time. Snapshots
+// saved during a write are coalesced, only the latest one is written next.
+type configWriter struct {
+	mu      sync.Mutex
+	pending *Code
+	writing bool
+}
+
+// save schedules write of c, starting a writer unless one is running
+func (w *configWriter) save(c Code, write func(Code)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.pending = &c
+	if w.writing {
+		return
+	}
+	w.writing = true
+
+	go func() {
+		for {
+			w.mu.Lock()
+			next := w.pending
+			w.pending = nil
+			if next == nil {
+				w.writing = false
+				w.mu.Unlock()
+				return
+			}
+			w.mu.Unlock()
+
+			write(*next)
+		}
+	}()
+}
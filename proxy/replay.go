@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+)
+
+// ReadCapture reads the frames of a capture file. If session is not empty,
+// only the frames of that session are returned.
+func ReadCapture(r io.Reader, session string) ([]Frame, error) {
+	var frames []Frame
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var f Frame
+		if err := json.Unmarshal(scanner.Bytes(), &f); err != nil {
+			return nil, fmt.Errorf("Invalid frame on line %d: %v", line, err)
+		}
+		if session == "" || f.Session == session {
+			frames = append(frames, f)
+		}
+	}
+	return frames, scanner.Err()
+}
+
+// ReplayFrontend plays the browser of the captured frames on conn, a
+// connection to the proxy or a code-server. See replay.
+func ReplayFrontend(conn *websocket.Conn, frames []Frame) error {
+	return replay(conn, frames, DirectionToBackend)
+}
+
+// ReplayBackend plays the code-server of the captured frames on conn, a
+// connection accepted from the proxy or a browser. See replay.
+func ReplayBackend(conn *websocket.Conn, frames []Frame) error {
+	return replay(conn, frames, DirectionToFrontend)
+}
+
+// ReplayHandler is a fake code-server which accepts websocket connections
+// and replays the code-server of the captured frames. Errors of replaying
+// are sent to errs, if it is not nil.
+func ReplayHandler(frames []Frame, errs chan<- error) http.HandlerFunc {
+	upgrader := websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err == nil {
+			defer conn.Close()
+			err = ReplayBackend(conn, frames)
+		}
+		if errs != nil {
+			errs <- err
+		}
+	}
+}
+
+// replay sends the frames in direction send on conn, and expects to
+// receive the others in order. Timing is not reproduced. Frames captured
+// with payload are sent as they are and compared on receipt, the others
+// are sent as zeros of their size and only compared by size. A close frame
+// ends the replay.
+func replay(conn *websocket.Conn, frames []Frame, send string) error {
+	for i, f := range frames {
+		mt, err := messageType(f.Type)
+		if err != nil {
+			return fmt.Errorf("Frame %d: %v", i, err)
+		}
+
+		if f.Direction == send {
+			if mt == websocket.CloseMessage {
+				return conn.WriteMessage(mt, websocket.FormatCloseMessage(f.Code, f.Text))
+			}
+			if err := conn.WriteMessage(mt, framePayload(f)); err != nil {
+				return fmt.Errorf("Frame %d: %v", i, err)
+			}
+			continue
+		}
+
+		got, payload, err := conn.ReadMessage()
+		if ce, ok := err.(*websocket.CloseError); ok && mt == websocket.CloseMessage {
+			if ce.Code != f.Code {
+				return fmt.Errorf("Frame %d: expected close %d, got %d", i, f.Code, ce.Code)
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Frame %d: %v", i, err)
+		}
+
+		switch {
+		case got != mt:
+			return fmt.Errorf("Frame %d: expected %s message, got %s", i, f.Type, frameType(got))
+		case int64(len(payload)) != f.Size:
+			return fmt.Errorf("Frame %d: expected %d bytes, got %d", i, f.Size, len(payload))
+		case f.Payload != nil && !bytes.Equal(payload, f.Payload):
+			return fmt.Errorf("Frame %d: payload differs", i)
+		}
+	}
+	return nil
+}
+
+// framePayload returns the payload of f, or zeros of its size if the
+// payload was not captured
+func framePayload(f Frame) []byte {
+	if f.Payload != nil || f.Size == 0 {
+		return f.Payload
+	}
+	return make([]byte, f.Size)
+}
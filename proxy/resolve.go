@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// RuleDefault routes by the unmatched policy when no resolver matches
+const RuleDefault = "default"
+
+// Schemes of code-servers
+const (
+	SchemeHTTP  = "http"
+	SchemeHTTPS = "https"
+)
+
+// ErrNoServer means there is no code-server to route a request to
+var ErrNoServer = errors.New("no code-server is registered")
+
+// Resolution describes how a request is routed to a code-server
+type Resolution struct {
+	Rule        string   `json:"rule"`
+	Matched     string   `json:"matched"`
+	Prefix      string   `json:"prefix"`
+	Server      Server   `json:"server"`
+	StripPrefix string   `json:"stripPrefix"`
+	Rewrites    []string `json:"rewrites,omitempty"`
+	BackendURL  string   `json:"backendURL"`
+	// ProjectPrefix is the path the project is served under by the proxy,
+	// empty if it is served from the root
+	ProjectPrefix string `json:"projectPrefix,omitempty"`
+
+	backendPath string
+}
+
+// resolve picks the code-server of r with the resolver chain and the URL
+// r is forwarded to
+func (p *Proxy) resolve(r *http.Request) (Resolution, error) {
+	p.mu.RLock()
+	routes := p.routes
+	p.mu.RUnlock()
+
+	res := Resolution{}
+	raw := false
+	for _, resolver := range p.resolvers {
+		if m, ok := resolver.Resolve(r, routes); ok {
+			res.Rule = resolver.Name()
+			res.Matched = m.Matched
+			res.Prefix = m.Prefix
+			res.Server = m.Server
+			res.StripPrefix = m.StripPrefix
+			raw = m.Raw
+			break
+		}
+	}
+
+	if res.Rule == "" {
+		s, err := p.unmatchedServer(routes)
+		if err != nil {
+			return res, err
+		}
+
+		res.Rule = RuleDefault
+		res.Matched = r.RequestURI
+		res.Server = s
+	}
+
+	res.backendPath = requestPath(r)
+	if !raw {
+		res.backendPath = trimPathPrefix(res.backendPath, res.StripPrefix)
+		if !strings.HasPrefix(res.backendPath, "/") {
+			res.backendPath = "/" + res.backendPath
+		}
+		res.backendPath, res.Rewrites = routes.rewrites(res.Server.Alias).rewrite(res.backendPath)
+
+		// Requests by parent directories belong to the project of their
+		// path, requests by root paths to the project of their alias
+		alias := fmt.Sprintf("/%s", res.Server.Alias)
+		switch {
+		case res.StripPrefix == res.Server.Path || res.StripPrefix == alias:
+			res.ProjectPrefix = res.StripPrefix
+		case res.StripPrefix != "":
+			res.ProjectPrefix = res.Server.Path
+		case res.Rule != RuleDefault:
+			res.ProjectPrefix = alias
+		}
+	}
+
+	backendURL := serverURL(res.Server, res.backendPath)
+	backendURL.RawQuery = requestQuery(r)
+	res.BackendURL = backendURL.String()
+
+	return res, nil
+}
+
+// serverURL returns the URL of path on the code-server s
+func serverURL(s Server, path string) url.URL {
+	scheme := s.Scheme
+	if scheme == "" {
+		scheme = SchemeHTTP
+	}
+	return url.URL{Scheme: scheme, Host: fmt.Sprintf("localhost:%d", s.Port), Path: path}
+}
+
+// resolveRequest resolves the request r being served, recording the result
+// in its span and access log entry
+func (p *Proxy) resolveRequest(r *http.Request) (Resolution, error) {
+	sp := p.startSpan(r, "resolve", SpanInternal)
+	defer sp.end()
+
+	res, err := p.resolve(r)
+	if err != nil {
+		sp.fail(err)
+		return res, err
+	}
+	sp.set("route.rule", res.Rule)
+	sp.set("route.matched", res.Matched)
+	sp.set("server", res.Server.Alias)
+	sp.set("backend", res.BackendURL)
+
+	accessRecorderOf(r).resolved(res)
+	return res, nil
+}
+
+// debugResolveHandler shows how a request for a path and referer is routed
+func (p *Proxy) debugResolveHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	requestPath := query.Get("path")
+	if requestPath == "" {
+		p.writeAPIError(w, r, &APIError{
+			Status:  http.StatusBadRequest,
+			Code:    ErrCodeInvalidRequest,
+			Message: "Query parameter path is required",
+		})
+		return
+	}
+
+	req := &http.Request{
+		Method:     "GET",
+		RequestURI: requestPath,
+		Header:     http.Header{},
+	}
+	if referer := query.Get("referer"); referer != "" {
+		req.Header.Set("Referer", referer)
+	}
+
+	res, err := p.resolve(req)
+	switch {
+	case err == ErrNoServer, err == ErrNoRoute:
+		p.writeAPIError(w, r, &APIError{
+			Status:  http.StatusNotFound,
+			Code:    ErrCodeNotFound,
+			Message: err.Error(),
+		})
+		return
+	case err != nil:
+		p.writeAPIError(w, r, &APIError{
+			Status:  http.StatusBadRequest,
+			Code:    ErrCodeInvalidRequest,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	p.writeJSON(w, http.StatusOK, res)
+}
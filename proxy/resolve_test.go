@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolve(t *testing.T) {
+	p, err := newTestProxy()
+	require.NoError(t, err)
+
+	data := []struct {
+		r   *http.Request
+		res Resolution
+	}{
+		{
+			&http.Request{RequestURI: "/a/b/c/mleu/cool"},
+			Resolution{
+				Rule:          RulePrefix,
+				Matched:       "/a/b/c/mleu/cool",
+				Prefix:        "/a/b/c",
+				Server:        Server{Path: "/a/b/c", Alias: "project1", Port: 9000},
+				StripPrefix:   "/a/b/c",
+				BackendURL:    "http://localhost:9000/mleu/cool",
+				ProjectPrefix: "/a/b/c",
+			},
+		},
+		{
+			&http.Request{
+				RequestURI: "/main.css",
+				Header:     http.Header{"Referer": []string{"http://localhost/project2/"}},
+			},
+			Resolution{
+				Rule:          RuleReferer,
+				Matched:       "/project2/",
+				Prefix:        "/project2",
+				Server:        Server{Path: "/a/b/f", Alias: "project2", Port: 9001},
+				BackendURL:    "http://localhost:9001/main.css",
+				ProjectPrefix: "/project2",
+			},
+		},
+		{
+			&http.Request{RequestURI: "/project3/login"},
+			Resolution{
+				Rule:          RuleAlias,
+				Matched:       "/project3/login",
+				Prefix:        "/project3",
+				Server:        Server{Path: "/a/d/e", Alias: "project3", Port: 9002},
+				StripPrefix:   "/project3",
+				Rewrites:      []string{RewriteLogin},
+				BackendURL:    "http://localhost:9002/login/login",
+				ProjectPrefix: "/project3",
+			},
+		},
+		{
+			&http.Request{RequestURI: "/unknown"},
+			Resolution{
+				Rule:       RuleDefault,
+				Matched:    "/unknown",
+				Server:     Server{Path: "/a/b/c", Alias: "project1", Port: 9000},
+				BackendURL: "http://localhost:9000/unknown",
+			},
+		},
+	}
+
+	for _, d := range data {
+		res, err := p.resolve(d.r)
+		require.NoError(t, err)
+
+		res.backendPath = ""
+		require.Equal(t, d.res, res)
+	}
+
+	// Invalid Referer doesn't match
+	res, err := p.resolve(&http.Request{
+		RequestURI: "/main.css",
+		Header:     http.Header{"Referer": []string{"%zz"}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, RuleDefault, res.Rule)
+}
+
+func TestResolveNoServer(t *testing.T) {
+	p, err := NewProxy(UseLogger(logrus.New()))
+	require.NoError(t, err)
+
+	_, err = p.resolve(&http.Request{RequestURI: "/a/b/c"})
+	require.Equal(t, ErrNoServer, err)
+}
+
+func TestDebugResolveHandler(t *testing.T) {
+	p, err := newTestProxy()
+	require.NoError(t, err)
+
+	query := url.Values{}
+	query.Set("path", "/static/main.js")
+	query.Set("referer", "https://example.com/a/d/e/")
+
+	req, err := http.NewRequest("GET", "/debug/resolve?"+query.Encode(), nil)
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, "incorrect response code")
+
+	res := Resolution{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+	require.Equal(t, RuleReferer, res.Rule)
+	require.Equal(t, "/a/d/e", res.Prefix)
+	require.Equal(t, "project3", res.Server.Alias)
+	require.Equal(t, "http://localhost:9002/static/main.js", res.BackendURL)
+
+	req, err = http.NewRequest("GET", "/debug/resolve", nil)
+	require.NoError(t, err)
+
+	rr = httptest.NewRecorder()
+	p.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusBadRequest, rr.Code, "incorrect response code")
+}
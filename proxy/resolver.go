@@ -0,0 +1,309 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Names of the built-in resolvers
+const (
+	// RuleAlias routes by the alias in the first segment of the request path
+	RuleAlias = "alias"
+	// RulePrefix routes by the longest prefix of the request path
+	RulePrefix = "prefix"
+	// RuleReferer routes by the longest prefix of the Referer path
+	RuleReferer = "referer"
+	// RuleHost routes by the alias in the subdomain of the host
+	RuleHost = "host"
+	// RuleCookie routes by the alias in a cookie
+	RuleCookie = "cookie"
+	// RuleHeader routes by the alias in a request header
+	RuleHeader = "header"
+)
+
+const (
+	defaultRoutingHeader = "X-Code-Server"
+	defaultRoutingCookie = "code-server-alias"
+)
+
+// DefaultResolvers is the resolver chain used when none is configured.
+// Paths are matched first, assets requested by root paths go to the
+// project the browser entered last, or fall back to the page that
+// references them. The host resolver goes first if a base domain is
+// configured.
+var DefaultResolvers = []string{RuleAlias, RulePrefix, RuleAffinity, RuleReferer}
+
+// Match is the code-server a resolver picked for a request
+type Match struct {
+	Server Server
+	// Matched is the part of the request that matched, e.g. a path or a host
+	Matched string
+	// Prefix is the route that matched, if the resolver matches routes
+	Prefix string
+	// StripPrefix is removed from the request path before it is forwarded
+	StripPrefix string
+	// Raw forwards the request path as is, without any rewrite
+	Raw bool
+}
+
+// Resolver picks the code-server of a request. HTTP and websocket requests
+// are resolved by the same chain of resolvers, the first match wins.
+type Resolver interface {
+	// Name identifies the resolver in configs and logs
+	Name() string
+	// Resolve returns the code-server of r in routes, or false if r
+	// doesn't carry what the resolver looks for.
+	Resolve(r *http.Request, routes *RouteTable) (Match, bool)
+}
+
+// NewResolver creates a built-in resolver by name
+func NewResolver(name string, routing Routing) (Resolver, error) {
+	switch name {
+	case RuleAlias:
+		return aliasResolver{}, nil
+	case RulePrefix:
+		return prefixResolver{}, nil
+	case RuleReferer:
+		return refererResolver{}, nil
+	case RuleHost:
+		return hostResolver{baseDomain: normalizeDomain(routing.BaseDomain)}, nil
+	case RuleCookie:
+		cookie := routing.Cookie
+		if cookie == "" {
+			cookie = defaultRoutingCookie
+		}
+		return cookieResolver{cookie: cookie}, nil
+	case RuleAffinity:
+		a, err := newAffinity(routing)
+		if err != nil {
+			return nil, err
+		}
+		return affinityResolver{affinity: a}, nil
+	case RuleHeader:
+		header := routing.Header
+		if header == "" {
+			header = defaultRoutingHeader
+		}
+		return headerResolver{header: http.CanonicalHeaderKey(header)}, nil
+	}
+	return nil, fmt.Errorf("Unknown resolver: %s", name)
+}
+
+// newResolvers creates the resolver chain configured in routing
+func newResolvers(routing Routing) ([]Resolver, error) {
+	names := routing.Resolvers
+	if len(names) == 0 {
+		names = DefaultResolvers
+	}
+
+	resolvers := make([]Resolver, 0, len(names))
+	for _, name := range names {
+		r, err := NewResolver(name, routing)
+		if err != nil {
+			return nil, err
+		}
+		resolvers = append(resolvers, r)
+	}
+	return resolvers, nil
+}
+
+// withHostResolver puts the host resolver of baseDomain first in resolvers,
+// as requests on subdomains are only accepted for the code-server of their
+// host
+func withHostResolver(resolvers []Resolver, baseDomain string) []Resolver {
+	chain := []Resolver{hostResolver{baseDomain: baseDomain}}
+	for _, r := range resolvers {
+		if r.Name() != RuleHost {
+			chain = append(chain, r)
+		}
+	}
+	return chain
+}
+
+// requestPath returns the path of r without query
+func requestPath(r *http.Request) string {
+	if r.URL != nil && r.URL.Path != "" {
+		return r.URL.Path
+	}
+	return strings.SplitN(r.RequestURI, "?", 2)[0]
+}
+
+// requestQuery returns the raw query of r
+func requestQuery(r *http.Request) string {
+	if r.URL != nil {
+		return r.URL.RawQuery
+	}
+
+	parts := strings.SplitN(r.RequestURI, "?", 2)
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[1]
+}
+
+// matchRoute resolves the longest route prefix of p
+func matchRoute(routes *RouteTable, p string) (Match, bool) {
+	route, ok := routes.LongestPrefix(p)
+	if !ok {
+		return Match{}, false
+	}
+
+	s, ok := routes.Server(route.Alias)
+	if !ok {
+		return Match{}, false
+	}
+
+	return Match{Server: s, Matched: p, Prefix: route.Prefix}, true
+}
+
+// matchAlias resolves the code-server registered under alias
+func matchAlias(routes *RouteTable, alias, matched string) (Match, bool) {
+	if alias == "" {
+		return Match{}, false
+	}
+
+	s, ok := routes.Server(alias)
+	if !ok {
+		return Match{}, false
+	}
+
+	return Match{Server: s, Matched: matched}, true
+}
+
+// aliasResolver routes /{alias}/... to the code-server of alias
+type aliasResolver struct{}
+
+func (aliasResolver) Name() string { return RuleAlias }
+
+func (aliasResolver) Resolve(r *http.Request, routes *RouteTable) (Match, bool) {
+	p := requestPath(r)
+	alias := strings.SplitN(strings.TrimPrefix(p, "/"), "/", 2)[0]
+
+	m, ok := matchAlias(routes, alias, p)
+	if !ok {
+		return m, false
+	}
+
+	m.Prefix = fmt.Sprintf("/%s", alias)
+	m.StripPrefix = m.Prefix
+	return m, true
+}
+
+// prefixResolver routes by the longest route prefix of the request path
+type prefixResolver struct{}
+
+func (prefixResolver) Name() string { return RulePrefix }
+
+func (prefixResolver) Resolve(r *http.Request, routes *RouteTable) (Match, bool) {
+	m, ok := matchRoute(routes, requestPath(r))
+	if !ok {
+		return m, false
+	}
+
+	m.StripPrefix = m.Prefix
+	return m, true
+}
+
+// refererResolver routes by the longest route prefix of the Referer path.
+// Assets of code-server are requested by root paths, the project is only
+// known from the page that references them.
+type refererResolver struct{}
+
+func (refererResolver) Name() string { return RuleReferer }
+
+func (refererResolver) Resolve(r *http.Request, routes *RouteTable) (Match, bool) {
+	if r.Referer() == "" {
+		return Match{}, false
+	}
+
+	u, err := url.Parse(r.Referer())
+	if err != nil {
+		return Match{}, false
+	}
+
+	return matchRoute(routes, u.Path)
+}
+
+// normalizeDomain lower-cases domain and strips its wildcard and trailing dot
+func normalizeDomain(domain string) string {
+	domain = strings.TrimPrefix(domain, "*.")
+	domain = strings.TrimSuffix(domain, ".")
+	return strings.ToLower(domain)
+}
+
+// hostResolver routes {alias}.{baseDomain} to the code-server of alias.
+// Requests are forwarded without rewriting, since code-server is served
+// from the root of its own host. Without base domain, the first label of
+// any host is taken as alias.
+type hostResolver struct {
+	baseDomain string
+}
+
+func (hostResolver) Name() string { return RuleHost }
+
+func (h hostResolver) Resolve(r *http.Request, routes *RouteTable) (Match, bool) {
+	host := r.Host
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	host = normalizeDomain(host)
+
+	var alias string
+	if h.baseDomain != "" {
+		suffix := fmt.Sprintf(".%s", h.baseDomain)
+		if !strings.HasSuffix(host, suffix) {
+			return Match{}, false
+		}
+		alias = strings.TrimSuffix(host, suffix)
+
+		// The wildcard covers a single label only
+		if strings.Contains(alias, ".") {
+			return Match{}, false
+		}
+	} else {
+		labels := strings.SplitN(host, ".", 2)
+		if len(labels) < 2 {
+			return Match{}, false
+		}
+		alias = labels[0]
+	}
+
+	// Host names are case-insensitive
+	for _, s := range routes.Servers() {
+		if strings.EqualFold(s.Alias, alias) {
+			return Match{Server: s, Matched: host, Raw: true}, true
+		}
+	}
+	return Match{}, false
+}
+
+// cookieResolver routes by the alias stored in a cookie
+type cookieResolver struct {
+	cookie string
+}
+
+func (cookieResolver) Name() string { return RuleCookie }
+
+func (c cookieResolver) Resolve(r *http.Request, routes *RouteTable) (Match, bool) {
+	cookie, err := r.Cookie(c.cookie)
+	if err != nil {
+		return Match{}, false
+	}
+
+	return matchAlias(routes, cookie.Value, cookie.String())
+}
+
+// headerResolver routes by the alias sent in a request header
+type headerResolver struct {
+	header string
+}
+
+func (headerResolver) Name() string { return RuleHeader }
+
+func (h headerResolver) Resolve(r *http.Request, routes *RouteTable) (Match, bool) {
+	alias := r.Header.Get(h.header)
+	return matchAlias(routes, alias, fmt.Sprintf("%s: %s", h.header, alias))
+}
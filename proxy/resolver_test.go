@@ -0,0 +1,214 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestResolverProxy(t *testing.T, routing Routing) *Proxy {
+	code, err := LoadConfig("./test.yaml")
+	require.NoError(t, err)
+	code.Routing = routing
+
+	p, err := NewProxy(UseCode(code), UseLogger(logrus.New()))
+	require.NoError(t, err)
+	return p
+}
+
+func TestResolvers(t *testing.T) {
+	p := newTestResolverProxy(t, Routing{
+		Resolvers: []string{RuleHeader, RuleCookie, RuleHost, RuleAlias, RulePrefix, RuleReferer},
+		Header:    "x-project",
+	})
+
+	data := []struct {
+		rule   string
+		alias  string
+		strip  string
+		header http.Header
+		host   string
+		uri    string
+	}{
+		{RuleHeader, "project2", "", http.Header{"X-Project": []string{"project2"}}, "", "/a/b/c/x"},
+		{RuleCookie, "project3", "", http.Header{"Cookie": []string{"code-server-alias=project3"}}, "", "/a/b/c/x"},
+		{RuleHost, "project2", "", http.Header{}, "project2.ide.example.com:443", "/a/b/c/x"},
+		{RuleAlias, "project3", "/project3", http.Header{}, "ide.example.com", "/project3/x"},
+		{RulePrefix, "project1", "/a/b/c", http.Header{}, "ide.example.com", "/a/b/c/x"},
+		{RuleReferer, "project2", "", http.Header{"Referer": []string{"https://ide.example.com/a/b/f"}}, "", "/static/x.js"},
+		{RuleDefault, "project1", "", http.Header{}, "localhost", "/static/x.js"},
+		// Unknown aliases fall through
+		{RuleAlias, "project3", "/project3", http.Header{"X-Project": []string{"nope"}}, "nope.example.com", "/project3/x"},
+	}
+
+	for _, d := range data {
+		res, err := p.resolve(&http.Request{Header: d.header, Host: d.host, RequestURI: d.uri})
+		require.NoError(t, err)
+		require.Equal(t, d.rule, res.Rule, "%+v", d)
+		require.Equal(t, d.alias, res.Server.Alias, "%+v", d)
+		require.Equal(t, d.strip, res.StripPrefix, "%+v", d)
+	}
+}
+
+func TestDefaultResolvers(t *testing.T) {
+	p := newTestResolverProxy(t, Routing{})
+
+	// Explicit paths win over the page that references them
+	res, err := p.resolve(&http.Request{
+		RequestURI: "/project2/",
+		Header:     http.Header{"Referer": []string{"https://ide.example.com/project1/"}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, RuleAlias, res.Rule)
+	require.Equal(t, "project2", res.Server.Alias)
+
+	// Header and cookie resolvers are not enabled by default
+	res, err = p.resolve(&http.Request{
+		RequestURI: "/static/x.js",
+		Header:     http.Header{"X-Code-Server": []string{"project2"}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, RuleDefault, res.Rule)
+}
+
+func TestAliasResolverSegment(t *testing.T) {
+	p := newTestResolverProxy(t, Routing{Resolvers: []string{RuleAlias}})
+
+	// project10 is not the alias project1
+	res, err := p.resolve(&http.Request{RequestURI: "/project10/x", Header: http.Header{}})
+	require.NoError(t, err)
+	require.Equal(t, RuleDefault, res.Rule)
+}
+
+func TestHostResolverBaseDomain(t *testing.T) {
+	p := newTestResolverProxy(t, Routing{BaseDomain: "*.ide.example.com."})
+
+	data := []struct {
+		host  string
+		uri   string
+		rule  string
+		alias string
+		url   string
+	}{
+		// Subdomains are forwarded without rewriting
+		{"project2.ide.example.com", "/login/x?a=b", RuleHost, "project2", "http://localhost:9001/login/x?a=b"},
+		{"Project3.IDE.example.com:443", "/static/x.js", RuleHost, "project3", "http://localhost:9002/static/x.js"},
+		// The wildcard covers a single label
+		{"a.project2.ide.example.com", "/static/x.js", RuleDefault, "project1", "http://localhost:9000/static/x.js"},
+		{"project2.example.com", "/project3/x", RuleAlias, "project3", "http://localhost:9002/x"},
+		{"ide.example.com", "/static/x.js", RuleDefault, "project1", "http://localhost:9000/static/x.js"},
+	}
+
+	for _, d := range data {
+		res, err := p.resolve(&http.Request{Header: http.Header{}, Host: d.host, RequestURI: d.uri})
+		require.NoError(t, err)
+		require.Equal(t, d.rule, res.Rule, "%+v", d)
+		require.Equal(t, d.alias, res.Server.Alias, "%+v", d)
+		require.Equal(t, d.url, res.BackendURL, "%+v", d)
+	}
+
+	require.Equal(t, "https://project1.ide.example.com/", p.aliasURL("ide.example.com", "project1"))
+	require.Equal(t, "https://project1.ide.example.com/", p.aliasURL("", "project1"))
+}
+
+func TestHostResolverExplicitChain(t *testing.T) {
+	routing := Routing{BaseDomain: "*.ide.example.com", Resolvers: []string{RuleAlias, RulePrefix}}
+	code, err := LoadConfig("./test.yaml")
+	require.NoError(t, err)
+	code.Routing = routing
+
+	configured := newTestResolverProxy(t, routing)
+	overridden, err := NewProxy(UseCode(code), UseLogger(logrus.New()), UseResolvers(aliasResolver{}, prefixResolver{}))
+	require.NoError(t, err)
+
+	// Subdomains are routed by their host, whatever the chain
+	for _, p := range []*Proxy{configured, overridden} {
+		res, err := p.resolve(&http.Request{Header: http.Header{}, Host: "project2.ide.example.com", RequestURI: "/static/x.js"})
+		require.NoError(t, err)
+		require.Equal(t, RuleHost, res.Rule)
+		require.Equal(t, "project2", res.Server.Alias)
+
+		res, err = p.resolve(&http.Request{Header: http.Header{}, Host: "ide.example.com", RequestURI: "/project3/x"})
+		require.NoError(t, err)
+		require.Equal(t, RuleAlias, res.Rule)
+	}
+}
+
+func TestResolveNestedWebsocketPath(t *testing.T) {
+	p := newTestResolverProxy(t, Routing{})
+
+	// Websocket requests are resolved like any other request
+	res, err := p.resolve(&http.Request{
+		RequestURI: "/a/d/e/nested/path",
+		Header:     http.Header{"Connection": []string{"upgrade"}, "Upgrade": []string{"websocket"}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 9002, res.Server.Port)
+}
+
+func TestUnknownResolver(t *testing.T) {
+	_, err := NewProxy(
+		UseCode(Code{Routing: Routing{Resolvers: []string{"nope"}}}),
+		UseLogger(logrus.New()),
+	)
+	require.Error(t, err)
+}
+
+type fixedResolver struct {
+	alias string
+}
+
+func (fixedResolver) Name() string { return "fixed" }
+
+func (f fixedResolver) Resolve(r *http.Request, routes *RouteTable) (Match, bool) {
+	s, ok := routes.Server(f.alias)
+	return Match{Server: s, Matched: f.alias}, ok
+}
+
+func TestUseResolvers(t *testing.T) {
+	code, err := LoadConfig("./test.yaml")
+	require.NoError(t, err)
+
+	p, err := NewProxy(
+		UseCode(code),
+		UseLogger(logrus.New()),
+		UseResolvers(fixedResolver{alias: "project3"}),
+	)
+	require.NoError(t, err)
+
+	res, err := p.resolve(&http.Request{RequestURI: "/a/b/c", Header: http.Header{}})
+	require.NoError(t, err)
+	require.Equal(t, "fixed", res.Rule)
+	require.Equal(t, 9002, res.Server.Port)
+}
+
+func TestPrefixResolverSegment(t *testing.T) {
+	p := newTestResolverProxy(t, Routing{Resolvers: []string{RulePrefix}})
+
+	data := []struct {
+		uri   string
+		rule  string
+		alias string
+		strip string
+		url   string
+	}{
+		// Prefixes match whole path segments only
+		{"/project10/x", RuleDefault, "project1", "", "http://localhost:9000/project10/x"},
+		{"/project1/x", RulePrefix, "project1", "/project1", "http://localhost:9000/x"},
+		{"/a/b/cd/x", RulePrefix, "project1", "/a/b", "http://localhost:9000/cd/x"},
+		{"/a/b/c/x", RulePrefix, "project1", "/a/b/c", "http://localhost:9000/x"},
+		{"/a/b/c", RulePrefix, "project1", "/a/b/c", "http://localhost:9000/"},
+		{"/a/d/ef", RulePrefix, "project3", "/a/d", "http://localhost:9002/ef"},
+	}
+
+	for _, d := range data {
+		res, err := p.resolve(&http.Request{Header: http.Header{}, RequestURI: d.uri})
+		require.NoError(t, err)
+		require.Equal(t, d.rule, res.Rule, "%+v", d)
+		require.Equal(t, d.alias, res.Server.Alias, "%+v", d)
+		require.Equal(t, d.strip, res.StripPrefix, "%+v", d)
+		require.Equal(t, d.url, res.BackendURL, "%+v", d)
+	}
+}
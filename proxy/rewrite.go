@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// RewriteLogin doubles the /login prefix expected by code-server
+const RewriteLogin = "login"
+
+// RewriteRule rewrites the path of requests forwarded to a code-server.
+// A rule applies if the path has StripPrefix as whole path segments and matches Match, whichever
+// are set. It then strips StripPrefix, replaces the matches of Match by
+// Replace and adds AddPrefix, in this order.
+type RewriteRule struct {
+	// Name identifies the rule in logs and /debug/resolve
+	Name        string `json:"name,omitempty" yaml:"name,omitempty"`
+	StripPrefix string `json:"stripPrefix,omitempty" yaml:"stripPrefix,omitempty"`
+	// Match is a regular expression, Replace may refer to its groups by $1
+	Match     string `json:"match,omitempty" yaml:"match,omitempty"`
+	Replace   string `json:"replace,omitempty" yaml:"replace,omitempty"`
+	AddPrefix string `json:"addPrefix,omitempty" yaml:"addPrefix,omitempty"`
+}
+
+// DefaultRewrites are the rewrite rules of code-servers without rules
+var DefaultRewrites = []RewriteRule{
+	{Name: RewriteLogin, Match: "^/login", Replace: "/login/login"},
+}
+
+// defaultRewriter applies DefaultRewrites
+var defaultRewriter, _ = compileRewrites(nil)
+
+// rewriter applies compiled rewrite rules
+type rewriter struct {
+	rules []compiledRule
+}
+
+type compiledRule struct {
+	RewriteRule
+	match *regexp.Regexp
+}
+
+// compileRewrites compiles rules, or DefaultRewrites if there are none
+func compileRewrites(rules []RewriteRule) (*rewriter, error) {
+	if len(rules) == 0 {
+		rules = DefaultRewrites
+	}
+
+	rw := &rewriter{rules: make([]compiledRule, 0, len(rules))}
+	for i, rule := range rules {
+		verr := &ValidationError{}
+		validateRewrite(verr, i, rule)
+		if len(verr.Fields) > 0 {
+			return nil, verr
+		}
+
+		c := compiledRule{RewriteRule: rule}
+		if c.Name == "" {
+			c.Name = fmt.Sprintf("rewrite[%d]", i)
+		}
+		if rule.Match != "" {
+			c.match = regexp.MustCompile(rule.Match)
+		}
+		rw.rules = append(rw.rules, c)
+	}
+	return rw, nil
+}
+
+// validateRewrite checks the i-th rewrite rule of a code-server
+func validateRewrite(verr *ValidationError, i int, rule RewriteRule) {
+	field := fmt.Sprintf("rewrite[%d]", i)
+
+	if rule.StripPrefix == "" && rule.Match == "" && rule.AddPrefix == "" {
+		verr.add(field, "must set stripPrefix, match or addPrefix")
+	}
+
+	if rule.StripPrefix != "" && !strings.HasPrefix(rule.StripPrefix, "/") {
+		verr.add(field+".stripPrefix", "must start with /")
+	}
+
+	if rule.AddPrefix != "" && !strings.HasPrefix(rule.AddPrefix, "/") {
+		verr.add(field+".addPrefix", "must start with /")
+	}
+
+	if rule.Match != "" {
+		if _, err := regexp.Compile(rule.Match); err != nil {
+			verr.add(field+".match", "is not a valid regular expression: %v", err)
+		}
+	} else if rule.Replace != "" {
+		verr.add(field+".replace", "requires match")
+	}
+}
+
+// rewrite applies the rules to p, and returns the names of the rules which
+// applied
+func (rw *rewriter) rewrite(p string) (string, []string) {
+	var applied []string
+	for _, rule := range rw.rules {
+		if rule.StripPrefix != "" && !hasPathPrefix(p, rule.StripPrefix) {
+			continue
+		}
+		if rule.match != nil && !rule.match.MatchString(p) {
+			continue
+		}
+
+		p = trimPathPrefix(p, rule.StripPrefix)
+		if rule.match != nil {
+			p = rule.match.ReplaceAllString(p, rule.Replace)
+		}
+		p = rule.AddPrefix + p
+
+		if !strings.HasPrefix(p, "/") {
+			p = "/" + p
+		}
+		applied = append(applied, rule.Name)
+	}
+	return p, applied
+}
@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRewrite(t *testing.T) {
+	rw, err := compileRewrites([]RewriteRule{
+		{Name: "api", StripPrefix: "/api", AddPrefix: "/v2"},
+		{Match: `^/static/([^/]+)/(.*)$`, Replace: "/assets/$2/$1"},
+		{Name: "root", AddPrefix: "/root"},
+	})
+	require.NoError(t, err)
+
+	data := []struct {
+		path     string
+		expected string
+		applied  []string
+	}{
+		{"/api/users", "/root/v2/users", []string{"api", "root"}},
+		{"/api", "/root/v2", []string{"api", "root"}},
+		{"/apis/users", "/root/apis/users", []string{"root"}},
+		{"/static/v1/main.js", "/root/assets/main.js/v1", []string{"rewrite[1]", "root"}},
+		{"/x", "/root/x", []string{"root"}},
+	}
+
+	for _, d := range data {
+		p, applied := rw.rewrite(d.path)
+		require.Equal(t, d.expected, p, d.path)
+		require.Equal(t, d.applied, applied, d.path)
+	}
+
+	// The login rule is applied without rules
+	rw, err = compileRewrites(nil)
+	require.NoError(t, err)
+
+	p, applied := rw.rewrite("/login/x")
+	require.Equal(t, "/login/login/x", p)
+	require.Equal(t, []string{RewriteLogin}, applied)
+
+	p, applied = rw.rewrite("/x/login")
+	require.Equal(t, "/x/login", p)
+	require.Empty(t, applied)
+}
+
+func TestRewriteValidation(t *testing.T) {
+	data := []struct {
+		rule   RewriteRule
+		fields []string
+	}{
+		{RewriteRule{}, []string{"rewrite[0]"}},
+		{RewriteRule{StripPrefix: "api"}, []string{"rewrite[0].stripPrefix"}},
+		{RewriteRule{AddPrefix: "api"}, []string{"rewrite[0].addPrefix"}},
+		{RewriteRule{Match: "("}, []string{"rewrite[0].match"}},
+		{RewriteRule{StripPrefix: "/api", Replace: "/x"}, []string{"rewrite[0].replace"}},
+	}
+
+	for _, d := range data {
+		_, err := compileRewrites([]RewriteRule{d.rule})
+		require.IsType(t, &ValidationError{}, err)
+
+		fields := []string{}
+		for _, f := range err.(*ValidationError).Fields {
+			fields = append(fields, f.Field)
+		}
+		require.Equal(t, d.fields, fields, "%+v", d.rule)
+	}
+
+	// Invalid rules are refused at startup
+	_, err := NewProxy(
+		UseCode(Code{Servers: []Server{
+			{Path: "/a/b/c", Alias: "project1", Port: 9000, Rewrite: []RewriteRule{{Match: "("}}},
+		}}),
+		UseLogger(logrus.New()),
+	)
+	require.Error(t, err)
+}
+
+func TestResolveRewrite(t *testing.T) {
+	p, err := NewProxy(
+		UseCode(Code{Servers: []Server{
+			{Path: "/a/b/c", Alias: "project1", Port: 9000, Rewrite: []RewriteRule{{Name: "base", AddPrefix: "/base"}}},
+			{Path: "/a/d/e", Alias: "project2", Port: 9001},
+		}}),
+		UseLogger(logrus.New()),
+	)
+	require.NoError(t, err)
+
+	// The query string is preserved
+	res, err := p.resolve(httptest.NewRequest("GET", "/project1/login?folder=%2Fa%2Fb&x=y", nil))
+	require.NoError(t, err)
+	require.Equal(t, "http://localhost:9000/base/login?folder=%2Fa%2Fb&x=y", res.BackendURL)
+	require.Equal(t, []string{"base"}, res.Rewrites)
+
+	res, err = p.resolve(httptest.NewRequest("GET", "/project2/login?x=y", nil))
+	require.NoError(t, err)
+	require.Equal(t, "http://localhost:9001/login/login?x=y", res.BackendURL)
+	require.Equal(t, []string{RewriteLogin}, res.Rewrites)
+}
@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Defaults of rotating files
+const (
+	DefaultMaxFileSize = 10 << 20
+	DefaultMaxFiles    = 5
+)
+
+// rotatingWriter appends to a file, which is moved to {path}.1 once it
+// reaches maxSize. Older files are shifted up to {path}.{maxFiles}, the
+// ones beyond are removed.
+type rotatingWriter struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// openRotatingWriter opens path for appending, maxSize and maxFiles default
+// to DefaultMaxFileSize and DefaultMaxFiles if zero
+func openRotatingWriter(path string, maxSize int64, maxFiles int) (*rotatingWriter, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxFileSize
+	}
+	if maxFiles <= 0 {
+		maxFiles = DefaultMaxFiles
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	w := &rotatingWriter{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// open opens the current file
+func (w *rotatingWriter) open() error {
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	w.file = f
+	w.size = info.Size()
+	return nil
+}
+
+// Write writes b to the current file, b is never split across files
+func (w *rotatingWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if w.size > 0 && w.size+int64(len(b)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.file.Write(b)
+	w.size += int64(n)
+	return n, err
+}
+
+// rotate moves the current file to {path}.1 and opens a new one. If moving
+// fails, the current file is reopened, so later writes retry rotating.
+func (w *rotatingWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	w.file = nil
+
+	if err := w.shift(); err != nil {
+		if oerr := w.open(); oerr != nil {
+			return fmt.Errorf("%v, and failed to reopen %s: %v", err, w.path, oerr)
+		}
+		return err
+	}
+	return w.open()
+}
+
+// shift moves the files up by one, the current file to {path}.1
+func (w *rotatingWriter) shift() error {
+	for i := w.maxFiles - 1; i >= 1; i-- {
+		from := fmt.Sprintf("%s.%d", w.path, i)
+		if err := os.Rename(from, fmt.Sprintf("%s.%d", w.path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(w.path, w.path+".1")
+}
+
+// Close closes the current file
+func (w *rotatingWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotatingWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "logs", "out.log")
+	w, err := openRotatingWriter(path, 10, 2)
+	require.NoError(t, err)
+
+	for _, line := range []string{"aaaa\n", "bbbb\n", "cccc\n", "dddd\n", "eeeeeeeeeeeeeeee\n", "ffff\n"} {
+		_, err := w.Write([]byte(line))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	for name, content := range map[string]string{
+		"out.log":   "ffff\n",
+		"out.log.1": "eeeeeeeeeeeeeeee\n",
+		"out.log.2": "cccc\ndddd\n",
+	} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, "logs", name))
+		require.NoError(t, err)
+		require.Equal(t, content, string(b), name)
+	}
+
+	_, err = os.Stat(path + ".3")
+	require.True(t, os.IsNotExist(err))
+
+	// Reopened files are appended to
+	w, err = openRotatingWriter(path, 10, 2)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("g\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	b, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "ffff\ng\n", string(b))
+
+	_, err = w.Write([]byte("h\n"))
+	require.Error(t, err)
+}
+
+func TestRotatingWriterRotateFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "out.log")
+	w, err := openRotatingWriter(path, 10, 1)
+	require.NoError(t, err)
+	defer w.Close()
+
+	// A directory in the way of out.log.1 fails the rotation
+	require.NoError(t, os.MkdirAll(filepath.Join(path+".1", "x"), 0755))
+
+	_, err = w.Write([]byte("aaaaaaaa\n"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("bbbb\n"))
+	require.Error(t, err)
+
+	// The writer stays open and rotates once it can
+	require.NoError(t, os.RemoveAll(path+".1"))
+	_, err = w.Write([]byte("cccc\n"))
+	require.NoError(t, err)
+
+	for name, content := range map[string]string{
+		"out.log":   "cccc\n",
+		"out.log.1": "aaaaaaaa\n",
+	} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		require.Equal(t, content, string(b), name)
+	}
+}
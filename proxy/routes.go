@@ -0,0 +1,255 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/armon/go-radix"
+)
+
+// Kinds of route prefixes
+const (
+	// RoutePath is the project path of a code-server
+	RoutePath = "path"
+	// RouteParent is the parent directory of a project path
+	RouteParent = "parent"
+	// RouteAlias is the alias of a code-server
+	RouteAlias = "alias"
+)
+
+// Severities of route conflicts
+const (
+	// ConflictError means requests can't be routed unambiguously
+	ConflictError = "error"
+	// ConflictWarning means a prefix is shadowed by a more specific route
+	ConflictWarning = "warning"
+)
+
+// Route is an entry of the routing table
+type Route struct {
+	Prefix string `json:"prefix"`
+	Kind   string `json:"kind"`
+	Alias  string `json:"alias"`
+	Port   int    `json:"port"`
+}
+
+// RouteConflict describes a prefix claimed by two routes. Route is the one
+// requests are routed to, Shadowed is the one that lost.
+type RouteConflict struct {
+	Severity string `json:"severity"`
+	Route    Route  `json:"route"`
+	Shadowed Route  `json:"shadowed"`
+}
+
+func (c RouteConflict) String() string {
+	return fmt.Sprintf("%s %s of %s shadows %s %s of %s",
+		c.Route.Kind, c.Route.Prefix, c.Route.Alias,
+		c.Shadowed.Kind, c.Shadowed.Prefix, c.Shadowed.Alias,
+	)
+}
+
+// RouteConflictError is returned when servers claim the same route
+type RouteConflictError struct {
+	Conflicts []RouteConflict
+}
+
+func (e *RouteConflictError) Error() string {
+	var b bytes.Buffer
+	b.WriteString("Ambiguous routes")
+	for i, c := range e.Conflicts {
+		if i == 0 {
+			b.WriteString(": ")
+		} else {
+			b.WriteString("; ")
+		}
+		fmt.Fprintf(&b, "%s %s is claimed by %s and %s",
+			c.Route.Kind, c.Route.Prefix, c.Route.Alias, c.Shadowed.Alias)
+	}
+	return b.String()
+}
+
+// RouteTable is an immutable snapshot of the routing table
+type RouteTable struct {
+	tree      *radix.Tree
+	servers   []Server
+	rewriters map[string]*rewriter
+}
+
+// Get returns the route of prefix
+func (t *RouteTable) Get(prefix string) (Route, bool) {
+	v, ok := t.tree.Get(prefix)
+	if !ok {
+		return Route{}, false
+	}
+	return v.(Route), true
+}
+
+// LongestPrefix returns the route with the longest prefix of p, which ends
+// at a segment of p
+func (t *RouteTable) LongestPrefix(p string) (Route, bool) {
+	var route Route
+	ok := false
+	t.tree.WalkPath(p, func(prefix string, v interface{}) bool {
+		if hasPathPrefix(p, prefix) {
+			route, ok = v.(Route), true
+		}
+		return false
+	})
+	return route, ok
+}
+
+// hasPathPrefix reports whether prefix is p or a path of its parent
+// directories, e.g. /a/b of /a/b/c but not of /a/bc
+func hasPathPrefix(p, prefix string) bool {
+	if p == prefix {
+		return true
+	}
+	return strings.HasPrefix(p, strings.TrimSuffix(prefix, "/")+"/")
+}
+
+// trimPathPrefix removes prefix from p if it is a path prefix of p
+func trimPathPrefix(p, prefix string) string {
+	if prefix == "" || !hasPathPrefix(p, prefix) {
+		return p
+	}
+	return strings.TrimPrefix(p, prefix)
+}
+
+// Routes returns all routes ordered by prefix
+func (t *RouteTable) Routes() []Route {
+	routes := make([]Route, 0, t.tree.Len())
+	t.tree.Walk(func(prefix string, v interface{}) bool {
+		routes = append(routes, v.(Route))
+		return false
+	})
+	return routes
+}
+
+// Server returns the code-server registered under alias
+func (t *RouteTable) Server(alias string) (Server, bool) {
+	for _, s := range t.servers {
+		if s.Alias == alias {
+			return s, true
+		}
+	}
+	return Server{}, false
+}
+
+// rewrites returns the rewrite rules of the code-server registered under alias
+func (t *RouteTable) rewrites(alias string) *rewriter {
+	if rw, ok := t.rewriters[alias]; ok {
+		return rw
+	}
+	return defaultRewriter
+}
+
+// Servers returns the code-servers in the order they are configured
+func (t *RouteTable) Servers() []Server {
+	servers := make([]Server, len(t.servers))
+	copy(servers, t.servers)
+	return servers
+}
+
+// RoutesResponse is the response of GET /debug/routes
+type RoutesResponse struct {
+	Routes    []Route         `json:"routes"`
+	Conflicts []RouteConflict `json:"conflicts"`
+}
+
+// buildRoutes builds the routing table of servers. Project paths and
+// aliases must be unique, so two of them claiming the same prefix is an
+// error. Parent directories are only a convenience, so they are shadowed
+// by project paths and aliases, and otherwise the first server configured
+// keeps a parent shared by several servers.
+func buildRoutes(servers []Server) (*RouteTable, []RouteConflict) {
+	tree := radix.New()
+	conflicts := []RouteConflict{}
+
+	insert := func(r Route) {
+		v, ok := tree.Get(r.Prefix)
+		if !ok {
+			tree.Insert(r.Prefix, r)
+			return
+		}
+
+		existing := v.(Route)
+		if existing.Alias == r.Alias {
+			return
+		}
+
+		severity := ConflictWarning
+		if existing.Kind != RouteParent && r.Kind != RouteParent {
+			severity = ConflictError
+		}
+
+		conflicts = append(conflicts, RouteConflict{
+			Severity: severity,
+			Route:    existing,
+			Shadowed: r,
+		})
+	}
+
+	for _, s := range servers {
+		insert(Route{Prefix: s.Path, Kind: RoutePath, Alias: s.Alias, Port: s.Port})
+		insert(Route{Prefix: fmt.Sprintf("/%s", s.Alias), Kind: RouteAlias, Alias: s.Alias, Port: s.Port})
+	}
+
+	for _, s := range servers {
+		insert(Route{Prefix: path.Dir(s.Path), Kind: RouteParent, Alias: s.Alias, Port: s.Port})
+	}
+
+	table := &RouteTable{
+		tree:      tree,
+		servers:   make([]Server, len(servers)),
+		rewriters: map[string]*rewriter{},
+	}
+	copy(table.servers, servers)
+
+	// Rewrite rules are validated before servers are registered
+	for _, s := range servers {
+		if rw, err := compileRewrites(s.Rewrite); err == nil {
+			table.rewriters[s.Alias] = rw
+		}
+	}
+
+	return table, conflicts
+}
+
+// routeErrors returns the conflicts of severity error
+func routeErrors(conflicts []RouteConflict) error {
+	errs := []RouteConflict{}
+	for _, c := range conflicts {
+		if c.Severity == ConflictError {
+			errs = append(errs, c)
+		}
+	}
+
+	if len(errs) > 0 {
+		return &RouteConflictError{Conflicts: errs}
+	}
+	return nil
+}
+
+// logRouteConflicts logs the conflicts of severity warning
+func (p *Proxy) logRouteConflicts(conflicts []RouteConflict) {
+	for _, c := range conflicts {
+		if c.Severity == ConflictWarning {
+			p.logger.Warnf("Shadowed route: %s", c)
+		}
+	}
+}
+
+// debugRoutesHandler shows the effective routing table
+func (p *Proxy) debugRoutesHandler(w http.ResponseWriter, r *http.Request) {
+	p.mu.RLock()
+	resp := RoutesResponse{
+		Routes:    p.routes.Routes(),
+		Conflicts: p.conflicts,
+	}
+	p.mu.RUnlock()
+
+	p.writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildRoutes(t *testing.T) {
+	code, err := LoadConfig("./test.yaml")
+	require.NoError(t, err)
+
+	routes, conflicts := buildRoutes(code.Servers)
+	require.NoError(t, routeErrors(conflicts))
+
+	// project1 and project2 share the parent /a/b, the first one keeps it
+	require.Equal(t, []RouteConflict{
+		{
+			Severity: ConflictWarning,
+			Route:    Route{Prefix: "/a/b", Kind: RouteParent, Alias: "project1", Port: 9000},
+			Shadowed: Route{Prefix: "/a/b", Kind: RouteParent, Alias: "project2", Port: 9001},
+		},
+	}, conflicts)
+
+	route, ok := routes.Get("/a/b")
+	require.True(t, ok)
+	require.Equal(t, "project1", route.Alias)
+	require.Len(t, routes.Routes(), 3*2+2)
+}
+
+func TestBuildRoutesShadowedParent(t *testing.T) {
+	_, conflicts := buildRoutes([]Server{
+		{Path: "/x/y", Alias: "project1", Port: 9000},
+		{Path: "/a/b", Alias: "x", Port: 9001},
+	})
+	require.NoError(t, routeErrors(conflicts))
+
+	// The alias of x wins over the parent directory of project1
+	require.Len(t, conflicts, 1)
+	require.Equal(t, ConflictWarning, conflicts[0].Severity)
+	require.Equal(t, Route{Prefix: "/x", Kind: RouteAlias, Alias: "x", Port: 9001}, conflicts[0].Route)
+	require.Equal(t, "project1", conflicts[0].Shadowed.Alias)
+}
+
+func TestBuildRoutesAmbiguous(t *testing.T) {
+	servers := []Server{
+		{Path: "/a/b/c", Alias: "project1", Port: 9000},
+		{Path: "/project1", Alias: "project2", Port: 9001},
+	}
+
+	_, conflicts := buildRoutes(servers)
+	err := routeErrors(conflicts)
+	require.Error(t, err)
+	require.IsType(t, &RouteConflictError{}, err)
+
+	_, err = NewProxy(UseCode(Code{Servers: servers}), UseLogger(logrus.New()))
+	require.Error(t, err)
+}
+
+func TestDebugRoutesHandler(t *testing.T) {
+	p, err := newTestProxy()
+	require.NoError(t, err)
+
+	req, err := http.NewRequest("GET", "/debug/routes", nil)
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, "incorrect response code")
+
+	resp := RoutesResponse{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+
+	require.Len(t, resp.Routes, 8)
+	require.Equal(t, Route{Prefix: "/a/b", Kind: RouteParent, Alias: "project1", Port: 9000}, resp.Routes[0])
+	require.Len(t, resp.Conflicts, 1)
+	require.Equal(t, "/a/b", resp.Conflicts[0].Route.Prefix)
+}
@@ -0,0 +1,265 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrSessionNotFound means no websocket session has the id
+var ErrSessionNotFound = errors.New("websocket session not found")
+
+// userHeaders carry the user authenticated by a trusted proxy in front,
+// e.g. oauth2-proxy
+var userHeaders = []string{"X-Forwarded-User", "X-Auth-Request-User", "X-Remote-User"}
+
+// Session describes an active websocket session
+type Session struct {
+	ID       string    `json:"id"`
+	Server   string    `json:"server"`
+	Path     string    `json:"path"`
+	ClientIP string    `json:"clientIP"`
+	User     string    `json:"user,omitempty"`
+	Start    time.Time `json:"start"`
+	// ToBackend counts the messages from the browser to the code-server
+	ToBackend Transfer `json:"toBackend"`
+	// ToFrontend counts the messages from the code-server to the browser
+	ToFrontend Transfer `json:"toFrontend"`
+}
+
+// Transfer counts the messages in one direction of a session
+type Transfer struct {
+	Messages int64 `json:"messages"`
+	Bytes    int64 `json:"bytes"`
+}
+
+// SessionList is the JSON body of GET /sessions
+type SessionList struct {
+	Sessions []Session `json:"sessions"`
+}
+
+// transferCounter counts the messages in one direction, accessed atomically
+type transferCounter struct {
+	messages int64
+	bytes    int64
+}
+
+func (c *transferCounter) add(n int64) {
+	atomic.AddInt64(&c.messages, 1)
+	atomic.AddInt64(&c.bytes, n)
+}
+
+func (c *transferCounter) transfer() Transfer {
+	return Transfer{
+		Messages: atomic.LoadInt64(&c.messages),
+		Bytes:    atomic.LoadInt64(&c.bytes),
+	}
+}
+
+// sessionRegistry tracks the active websocket sessions
+type sessionRegistry struct {
+	mu       sync.Mutex
+	sessions map[string]*session
+	// active counts the sessions of each code-server, including handshakes
+	active map[string]int
+}
+
+// add registers s under a new id
+func (reg *sessionRegistry) add(s *session) error {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Errorf("Failed to generate session id: %v", err)
+	}
+	s.id = hex.EncodeToString(b)
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if reg.sessions == nil {
+		reg.sessions = map[string]*session{}
+	}
+	reg.sessions[s.id] = s
+	return nil
+}
+
+// remove unregisters s
+func (reg *sessionRegistry) remove(s *session) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	delete(reg.sessions, s.id)
+}
+
+// get returns the session with id
+func (reg *sessionRegistry) get(id string) (*session, bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	s, ok := reg.sessions[id]
+	return s, ok
+}
+
+// count returns the number of active sessions by code-server
+func (reg *sessionRegistry) count() map[string]int {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	active := make(map[string]int, len(reg.active))
+	for alias, n := range reg.active {
+		active[alias] = n
+	}
+	return active
+}
+
+// list returns the sessions of the code-server alias, or all sessions if
+// alias is empty, oldest first
+func (reg *sessionRegistry) list(alias string) []Session {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	sessions := []Session{}
+	for _, s := range reg.sessions {
+		if alias == "" || s.info.Server == alias {
+			sessions = append(sessions, s.describe())
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		if sessions[i].Start.Equal(sessions[j].Start) {
+			return sessions[i].ID < sessions[j].ID
+		}
+		return sessions[i].Start.Before(sessions[j].Start)
+	})
+	return sessions
+}
+
+// trustedProxies are the networks of proxies in front, e.g. nginx
+type trustedProxies []*net.IPNet
+
+// parseTrustedProxies parses IPs and CIDRs of trusted proxies
+func parseTrustedProxies(addrs []string) (trustedProxies, error) {
+	var t trustedProxies
+	for _, addr := range addrs {
+		if !strings.Contains(addr, "/") {
+			ip := net.ParseIP(addr)
+			if ip == nil {
+				return nil, fmt.Errorf("Invalid trusted proxy: %s", addr)
+			}
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			addr = fmt.Sprintf("%s/%d", addr, bits)
+		}
+
+		_, network, err := net.ParseCIDR(addr)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid trusted proxy: %v", err)
+		}
+		t = append(t, network)
+	}
+	return t, nil
+}
+
+// contains reports whether ip is a trusted proxy
+func (t trustedProxies) contains(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range t {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIP returns the IP of the peer of r
+func remoteIP(r *http.Request) string {
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return ip
+	}
+	return r.RemoteAddr
+}
+
+// clientIP returns the IP of the browser of r. X-Forwarded-For is only
+// honored from trusted proxies, its last address which isn't one is the
+// browser.
+func (t trustedProxies) clientIP(r *http.Request) string {
+	ip := remoteIP(r)
+	if !t.contains(ip) {
+		return ip
+	}
+
+	addrs := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(addrs) - 1; i >= 0; i-- {
+		addr := strings.TrimSpace(addrs[i])
+		if addr == "" {
+			continue
+		}
+		ip = addr
+		if !t.contains(addr) {
+			break
+		}
+	}
+	return ip
+}
+
+// requestUser returns the user of r as authenticated by a trusted proxy in
+// front, by its user headers or the basic auth it checked. The proxy checks
+// no credentials itself, so other peers have no user.
+func (t trustedProxies) requestUser(r *http.Request) string {
+	if !t.contains(remoteIP(r)) {
+		return ""
+	}
+	for _, h := range userHeaders {
+		if user := r.Header.Get(h); user != "" {
+			return user
+		}
+	}
+	if user, _, ok := r.BasicAuth(); ok {
+		return user
+	}
+	return ""
+}
+
+// listSessionsHandler handles GET /sessions, optionally filtered by the
+// code-server alias of ?server=
+func (p *Proxy) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	p.writeJSON(w, http.StatusOK, SessionList{Sessions: p.sessions.list(r.URL.Query().Get("server"))})
+}
+
+// closeSessionHandler handles DELETE /sessions/{id}
+func (p *Proxy) closeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	s, ok := p.sessions.get(id)
+	if !ok {
+		p.writeAPIError(w, r, &APIError{
+			Status:  http.StatusNotFound,
+			Code:    ErrCodeNotFound,
+			Message: fmt.Sprintf("%v: %s", ErrSessionNotFound, id),
+		})
+		return
+	}
+
+	p.logger.WithFields(logrus.Fields{
+		"session": id,
+		"server":  s.info.Server,
+	}).Info("Close websocket session")
+
+	s.terminate("closed by administrator")
+	w.WriteHeader(http.StatusNoContent)
+}
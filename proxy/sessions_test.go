@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+func listSessions(t *testing.T, p *Proxy, target string) []Session {
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var list SessionList
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	return list.Sessions
+}
+
+func TestSessions(t *testing.T) {
+	p, wsURL, cleanup := newTestWebsocketProxy(t, echo(websocket.Upgrader{CheckOrigin: anyOrigin}, nil),
+		useTrustedProxies("127.0.0.1"))
+	defer cleanup()
+
+	require.Empty(t, listSessions(t, p, "/sessions"))
+
+	header := http.Header{"X-Forwarded-User": {"alice"}}
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"/project1/", header)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("hello")))
+	_, _, err = conn.ReadMessage()
+	require.NoError(t, err)
+
+	sessions := listSessions(t, p, "/sessions")
+	require.Len(t, sessions, 1)
+	s := sessions[0]
+	require.NotEmpty(t, s.ID)
+	require.Equal(t, "project1", s.Server)
+	require.Equal(t, "/project1/", s.Path)
+	require.Equal(t, "127.0.0.1", s.ClientIP)
+	require.Equal(t, "alice", s.User)
+	require.WithinDuration(t, time.Now(), s.Start, time.Minute)
+	require.Equal(t, Transfer{Messages: 1, Bytes: 5}, s.ToBackend)
+	require.Equal(t, Transfer{Messages: 1, Bytes: 5}, s.ToFrontend)
+
+	require.Len(t, listSessions(t, p, "/sessions?server=project1"), 1)
+	require.Empty(t, listSessions(t, p, "/sessions?server=project2"))
+
+	// Closing the session sends a close frame to the browser
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("DELETE", "/sessions/"+s.ID, nil))
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	_, _, err = conn.ReadMessage()
+	require.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), "%v", err)
+	require.Contains(t, err.Error(), "closed by administrator")
+	conn.Close()
+
+	// The session is unregistered once both pumps stopped
+	deadline := time.Now().Add(5 * time.Second)
+	for len(listSessions(t, p, "/sessions")) > 0 {
+		require.True(t, time.Now().Before(deadline), "session was not unregistered")
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCloseUnknownSession(t *testing.T) {
+	p, _, cleanup := newTestWebsocketProxy(t, echo(websocket.Upgrader{CheckOrigin: anyOrigin}, nil))
+	defer cleanup()
+
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("DELETE", "/sessions/unknown", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+	require.Contains(t, w.Body.String(), ErrCodeNotFound)
+}
+
+// useTrustedProxies trusts the forwarded headers of addrs
+func useTrustedProxies(addrs ...string) func(*Proxy) error {
+	return func(p *Proxy) error {
+		p.code.TrustedProxies = addrs
+		return nil
+	}
+}
+
+func TestClientIPAndUser(t *testing.T) {
+	trusted, err := parseTrustedProxies([]string{"10.0.0.0/24", "::1"})
+	require.NoError(t, err)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.2:1234"
+	require.Equal(t, "10.0.0.2", trusted.clientIP(r))
+	require.Equal(t, "", trusted.requestUser(r))
+
+	// Addresses of trusted proxies are skipped
+	r.Header.Set("X-Forwarded-For", "1.2.3.4, 192.168.1.5, 10.0.0.1")
+	require.Equal(t, "192.168.1.5", trusted.clientIP(r))
+
+	r.SetBasicAuth("bob", "secret")
+	require.Equal(t, "bob", trusted.requestUser(r))
+
+	r.Header.Set("X-Auth-Request-User", "alice")
+	require.Equal(t, "alice", trusted.requestUser(r))
+
+	// Forwarded headers and basic auth of other peers are ignored
+	r.RemoteAddr = "192.168.1.9:1234"
+	require.Equal(t, "192.168.1.9", trusted.clientIP(r))
+	require.Equal(t, "", trusted.requestUser(r))
+
+	r.RemoteAddr = "[::1]:1234"
+	require.Equal(t, "192.168.1.5", trusted.clientIP(r))
+
+	require.Equal(t, "192.168.1.9", trustedProxies(nil).clientIP(&http.Request{RemoteAddr: "192.168.1.9:1234", Header: r.Header}))
+}
+
+func TestInvalidTrustedProxies(t *testing.T) {
+	for _, addr := range []string{"nginx", "10.0.0.0/33"} {
+		_, err := parseTrustedProxies([]string{addr})
+		require.Error(t, err, addr)
+	}
+}
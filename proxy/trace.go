@@ -0,0 +1,277 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Built-in exporters of spans
+const (
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+)
+
+// DefaultTraceFile is the file spans are exported to if none is configured
+const DefaultTraceFile = "traces.jsonl"
+
+// Kinds of spans
+const (
+	SpanServer   = "server"
+	SpanClient   = "client"
+	SpanInternal = "internal"
+)
+
+// Statuses of spans
+const (
+	SpanStatusOK    = "ok"
+	SpanStatusError = "error"
+)
+
+// traceParentHeader propagates the span context of W3C Trace Context
+const traceParentHeader = "traceparent"
+
+// Tracing configures the tracing of requests, websocket sessions and health
+// probes
+type Tracing struct {
+	// Exporter is stdout or file, tracing is disabled if empty unless an
+	// exporter is set by UseExporter
+	Exporter string `yaml:"exporter,omitempty"`
+	// File is the JSON lines file of the file exporter, DefaultTraceFile if empty
+	File string `yaml:"file,omitempty"`
+	// MaxSize is the size in bytes a file is rotated at, DefaultMaxFileSize if zero
+	MaxSize int64 `yaml:"maxSize,omitempty"`
+	// MaxFiles is the number of rotated files kept, DefaultMaxFiles if zero
+	MaxFiles int `yaml:"maxFiles,omitempty"`
+}
+
+// Span is a timed operation of a trace
+type Span struct {
+	Name     string `json:"name"`
+	Kind     string `json:"kind"`
+	TraceID  string `json:"traceId"`
+	SpanID   string `json:"spanId"`
+	ParentID string `json:"parentId,omitempty"`
+	// Remote reports whether the parent is a span of another process
+	Remote     bool                   `json:"remote,omitempty"`
+	Start      time.Time              `json:"start"`
+	End        time.Time              `json:"end"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	Status     string                 `json:"status"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+// Exporter receives the sampled spans once they end. It is called
+// concurrently.
+type Exporter interface {
+	ExportSpan(s Span) error
+}
+
+// UseExporter sets the exporter of spans, which enables tracing
+func UseExporter(e Exporter) func(*Proxy) error {
+	return func(p *Proxy) error {
+		p.exporter = e
+		return nil
+	}
+}
+
+// writerExporter writes spans as JSON lines
+type writerExporter struct {
+	out io.Writer
+
+	// mu keeps concurrent spans on separate lines
+	mu sync.Mutex
+}
+
+// NewWriterExporter returns an exporter writing spans to w as JSON lines
+func NewWriterExporter(w io.Writer) Exporter {
+	return &writerExporter{out: w}
+}
+
+func (e *writerExporter) ExportSpan(s Span) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err = e.out.Write(append(b, '\n'))
+	return err
+}
+
+// newExporter opens the built-in exporter of c, it returns nil if none is
+// configured. The closer releases the file of the file exporter.
+func newExporter(c Tracing) (Exporter, io.Closer, error) {
+	switch c.Exporter {
+	case "":
+		return nil, nil, nil
+	case ExporterStdout:
+		return NewWriterExporter(os.Stdout), nil, nil
+	case ExporterFile:
+		file := c.File
+		if file == "" {
+			file = DefaultTraceFile
+		}
+		out, err := openRotatingWriter(file, c.MaxSize, c.MaxFiles)
+		if err != nil {
+			return nil, nil, err
+		}
+		return NewWriterExporter(out), out, nil
+	}
+	return nil, nil, fmt.Errorf("Unknown trace exporter: %s", c.Exporter)
+}
+
+// SpanContext identifies a span across processes
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// valid reports whether sc has non-zero IDs
+func (sc SpanContext) valid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// TraceParent returns the traceparent header of sc
+func (sc SpanContext) TraceParent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", hex.EncodeToString(sc.TraceID[:]), hex.EncodeToString(sc.SpanID[:]), flags)
+}
+
+// ParseTraceParent parses a traceparent header of W3C Trace Context
+func ParseTraceParent(header string) (SpanContext, bool) {
+	var sc SpanContext
+
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, false
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 || strings.ToLower(parts[1]+parts[2]) != parts[1]+parts[2] {
+		return sc, false
+	}
+
+	var flags [1]byte
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.valid()
+}
+
+// tracer starts spans and exports them once they end. A nil tracer starts
+// nil spans, which record nothing.
+type tracer struct {
+	exporter Exporter
+	logger   *logrus.Logger
+}
+
+// start starts the span name of kind, as child of parent if valid, or of a
+// new sampled trace otherwise
+func (t *tracer) start(name, kind string, parent SpanContext, remote bool) *span {
+	if t == nil {
+		return nil
+	}
+
+	s := &span{tracer: t, data: Span{Name: name, Kind: kind, Start: time.Now(), Status: SpanStatusOK}}
+	if parent.valid() {
+		s.context.TraceID = parent.TraceID
+		s.context.Sampled = parent.Sampled
+		s.data.ParentID = hex.EncodeToString(parent.SpanID[:])
+		s.data.Remote = remote
+	} else {
+		rand.Read(s.context.TraceID[:])
+		s.context.Sampled = true
+	}
+	rand.Read(s.context.SpanID[:])
+
+	s.data.TraceID = hex.EncodeToString(s.context.TraceID[:])
+	s.data.SpanID = hex.EncodeToString(s.context.SpanID[:])
+	return s
+}
+
+// span is a span being recorded, it is used by a single goroutine
+type span struct {
+	tracer  *tracer
+	context SpanContext
+	data    Span
+}
+
+// set sets the attribute key
+func (s *span) set(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	if s.data.Attributes == nil {
+		s.data.Attributes = map[string]interface{}{}
+	}
+	s.data.Attributes[key] = value
+}
+
+// fail marks the span as failed with err
+func (s *span) fail(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.data.Status = SpanStatusError
+	s.data.Error = err.Error()
+}
+
+// end ends the span, and exports it if sampled
+func (s *span) end() {
+	if s == nil {
+		return
+	}
+	s.data.End = time.Now()
+	if !s.context.Sampled {
+		return
+	}
+	if err := s.tracer.exporter.ExportSpan(s.data); err != nil {
+		s.tracer.logger.Errorf("Failed to export span: %v", err)
+	}
+}
+
+// inject sets the traceparent of header to the span, so the code-server
+// continues its trace
+func (s *span) inject(header http.Header) {
+	if s == nil {
+		return
+	}
+	header.Set(traceParentHeader, s.context.TraceParent())
+}
+
+type spanKey struct{}
+
+// spanOf returns the span of the request r, nil if tracing is disabled
+func spanOf(r *http.Request) *span {
+	s, _ := r.Context().Value(spanKey{}).(*span)
+	return s
+}
+
+// startSpan starts the span name of kind as child of the span of r
+func (p *Proxy) startSpan(r *http.Request, name, kind string) *span {
+	parent := spanOf(r)
+	if parent == nil {
+		return p.tracer.start(name, kind, SpanContext{}, false)
+	}
+	return p.tracer.start(name, kind, parent.context, false)
+}
@@ -0,0 +1,267 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryExporter keeps the exported spans
+type memoryExporter struct {
+	mu    sync.Mutex
+	spans []Span
+}
+
+func (e *memoryExporter) ExportSpan(s Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, s)
+	return nil
+}
+
+// exported returns the spans exported so far by name
+func (e *memoryExporter) exported() map[string]Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	spans := map[string]Span{}
+	for _, s := range e.spans {
+		spans[s.Name] = s
+	}
+	return spans
+}
+
+// waitSpans waits for n spans to be exported, the request span ends after
+// the response was sent
+func (e *memoryExporter) waitSpans(t *testing.T, n int) map[string]Span {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		spans := e.exported()
+		if len(spans) >= n {
+			return spans
+		}
+		require.True(t, time.Now().Before(deadline), "%d of %d spans exported", len(spans), n)
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestParseTraceParent(t *testing.T) {
+	tests := []struct {
+		header  string
+		valid   bool
+		sampled bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false, false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01", false, false},
+		{"", false, false},
+	}
+
+	for _, test := range tests {
+		sc, ok := ParseTraceParent(test.header)
+		require.Equal(t, test.valid, ok, test.header)
+		if ok {
+			require.Equal(t, test.sampled, sc.Sampled, test.header)
+		}
+	}
+
+	sc, ok := ParseTraceParent(tests[0].header)
+	require.True(t, ok)
+	require.Equal(t, tests[0].header, sc.TraceParent())
+}
+
+func TestTracingRequest(t *testing.T) {
+	var traceParent string
+	exporter := &memoryExporter{}
+	_, wsURL, cleanup := newTestWebsocketProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		traceParent = r.Header.Get(traceParentHeader)
+		fmt.Fprint(w, "hello")
+	}, UseExporter(exporter))
+	defer cleanup()
+
+	req, err := http.NewRequest("GET", strings.Replace(wsURL, "ws://", "http://", 1)+"/project1/", nil)
+	require.NoError(t, err)
+	req.Header.Set(traceParentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	spans := exporter.waitSpans(t, 3)
+
+	server := spans["HTTP GET"]
+	require.Equal(t, SpanServer, server.Kind)
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.TraceID)
+	require.Equal(t, "00f067aa0ba902b7", server.ParentID)
+	require.True(t, server.Remote)
+	require.Equal(t, float64(http.StatusOK), toFloat(server.Attributes["http.status_code"]))
+	require.Equal(t, "project1", server.Attributes["server"])
+
+	resolve := spans["resolve"]
+	require.Equal(t, server.SpanID, resolve.ParentID)
+	require.Equal(t, RuleAlias, resolve.Attributes["route.rule"])
+
+	backend := spans["backend GET"]
+	require.Equal(t, SpanClient, backend.Kind)
+	require.Equal(t, server.SpanID, backend.ParentID)
+	require.Equal(t, server.TraceID, backend.TraceID)
+	require.Equal(t, SpanStatusOK, backend.Status)
+
+	// The code-server continues the trace from the backend round trip
+	require.Equal(t, fmt.Sprintf("00-%s-%s-01", backend.TraceID, backend.SpanID), traceParent)
+}
+
+func TestTracingNotSampled(t *testing.T) {
+	var traceParent string
+	exporter := &memoryExporter{}
+	_, wsURL, cleanup := newTestWebsocketProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		traceParent = r.Header.Get(traceParentHeader)
+	}, UseExporter(exporter))
+	defer cleanup()
+
+	req, err := http.NewRequest("GET", strings.Replace(wsURL, "ws://", "http://", 1)+"/project1/", nil)
+	require.NoError(t, err)
+	req.Header.Set(traceParentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	require.Empty(t, exporter.exported())
+	require.NotEmpty(t, traceParent)
+	require.True(t, strings.HasPrefix(traceParent, "00-4bf92f3577b34da6a3ce929d0e0e4736-"), traceParent)
+	require.True(t, strings.HasSuffix(traceParent, "-00"), traceParent)
+}
+
+func TestTracingBackendError(t *testing.T) {
+	port, closeBackend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {})
+	closeBackend()
+
+	exporter := &memoryExporter{}
+	p, err := NewProxy(UseLogger(logrus.New()), UseExporter(exporter),
+		UseCode(Code{Servers: []Server{{Path: "/a/b/c", Alias: "project1", Port: port}}}))
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "/project1/", nil))
+
+	spans := exporter.waitSpans(t, 3)
+	require.Equal(t, SpanStatusOK, spans["resolve"].Status)
+	for _, name := range []string{"backend GET", "HTTP GET"} {
+		require.Equal(t, SpanStatusError, spans[name].Status, name)
+		require.NotEmpty(t, spans[name].Error, name)
+	}
+}
+
+func TestTracingWebsocket(t *testing.T) {
+	for _, mode := range []string{ModeFrame, ModeRaw} {
+		var traceParent string
+		upgrader := websocket.Upgrader{CheckOrigin: anyOrigin}
+		exporter := &memoryExporter{}
+		p, wsURL, cleanup := newTestWebsocketProxy(t, func(w http.ResponseWriter, r *http.Request) {
+			traceParent = r.Header.Get(traceParentHeader)
+			echo(upgrader, nil)(w, r)
+		}, UseExporter(exporter), useWebsocket(Websocket{Mode: mode}))
+
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL+"/project1/", nil)
+		require.NoError(t, err)
+		require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("hello")))
+		_, _, err = conn.ReadMessage()
+		require.NoError(t, err)
+		conn.Close()
+		waitSessions(t, p)
+		cleanup()
+
+		spans := exporter.waitSpans(t, 4)
+
+		dial := spans["websocket dial"]
+		require.Equal(t, float64(http.StatusSwitchingProtocols), toFloat(dial.Attributes["http.status_code"]), mode)
+		require.Equal(t, fmt.Sprintf("00-%s-%s-01", dial.TraceID, dial.SpanID), traceParent, mode)
+
+		session := spans["websocket session"]
+		require.Equal(t, spans["HTTP GET"].SpanID, session.ParentID, mode)
+		require.NotEmpty(t, session.Attributes["session"], mode)
+		require.Contains(t, session.Attributes["session.end_reason"], sideFrontend, mode)
+		require.Equal(t, float64(http.StatusSwitchingProtocols), toFloat(spans["HTTP GET"].Attributes["http.status_code"]), mode)
+	}
+}
+
+func TestTracingProbe(t *testing.T) {
+	port, closeBackend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"hostname":"ide"}`)
+	})
+	defer closeBackend()
+
+	exporter := &memoryExporter{}
+	p, err := NewProxy(UseLogger(logrus.New()), UseExporter(exporter),
+		UseCode(Code{Servers: []Server{{Path: "/a/b/c", Alias: "project1", Port: port}}}))
+	require.NoError(t, err)
+
+	p.healthCheck("")
+
+	probe := exporter.exported()["probe"]
+	require.Equal(t, SpanClient, probe.Kind)
+	require.Empty(t, probe.ParentID)
+	require.Equal(t, "project1", probe.Attributes["server"])
+	require.Equal(t, probeOK, probe.Attributes["probe.result"])
+}
+
+func TestTracingFileExporter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "code-server-proxy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "traces.jsonl")
+
+	p, err := NewProxy(UseLogger(logrus.New()), UseCode(Code{Tracing: Tracing{Exporter: ExporterFile, File: file}}))
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "/sessions", nil))
+	require.NoError(t, p.Close())
+
+	b, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+
+	var s Span
+	require.NoError(t, json.Unmarshal(b, &s))
+	require.Equal(t, "HTTP GET", s.Name)
+	require.Equal(t, "/sessions", s.Attributes["http.target"])
+	require.Len(t, s.TraceID, 32)
+	require.Len(t, s.SpanID, 16)
+	require.Empty(t, s.ParentID)
+}
+
+func TestInvalidTraceExporter(t *testing.T) {
+	_, err := NewProxy(UseLogger(logrus.New()), UseCode(Code{Tracing: Tracing{Exporter: "jaeger"}}))
+	require.Error(t, err)
+}
+
+// toFloat converts the numeric attribute v of an exported or decoded span
+func toFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case float64:
+		return n
+	}
+	return -1
+}
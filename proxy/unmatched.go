@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Policies for requests no resolver matches
+const (
+	// UnmatchedFirst routes to the first code-server configured
+	UnmatchedFirst = "first"
+	// UnmatchedServer routes to the code-server of Unmatched.Server
+	UnmatchedServer = "server"
+	// UnmatchedNotFound responds with a page listing the projects
+	UnmatchedNotFound = "notfound"
+	// UnmatchedRedirect redirects to Unmatched.Redirect
+	UnmatchedRedirect = "redirect"
+)
+
+// ErrNoRoute means no resolver matches a request and the unmatched policy
+// doesn't route it to a code-server
+var ErrNoRoute = errors.New("no code-server matches the request")
+
+// Unmatched configures how requests no resolver matches are handled
+type Unmatched struct {
+	// Policy is one of first, server, notfound and redirect, first if empty
+	Policy string `yaml:"policy,omitempty"`
+	// Server is the alias of the default code-server of policy server
+	Server string `yaml:"server,omitempty"`
+	// Redirect is the landing page of policy redirect
+	Redirect string `yaml:"redirect,omitempty"`
+}
+
+// validateUnmatched checks the unmatched policy against the code-servers
+// configured at startup
+func validateUnmatched(u Unmatched, routes *RouteTable) error {
+	switch u.Policy {
+	case "", UnmatchedFirst, UnmatchedNotFound:
+		return nil
+	case UnmatchedServer:
+		if u.Server == "" {
+			return errors.New("Unmatched policy server requires a server")
+		}
+		if _, ok := routes.Server(u.Server); !ok {
+			return fmt.Errorf("Unmatched policy server: %s is not registered", u.Server)
+		}
+		return nil
+	case UnmatchedRedirect:
+		if u.Redirect == "" {
+			return errors.New("Unmatched policy redirect requires a redirect")
+		}
+		target, err := url.Parse(u.Redirect)
+		if err != nil {
+			return fmt.Errorf("Unmatched policy redirect: %v", err)
+		}
+		if !target.IsAbs() && (target.Host != "" || len(target.Path) == 0 || target.Path[0] != '/') {
+			return fmt.Errorf("Unmatched policy redirect: %s must be an absolute URL or path", u.Redirect)
+		}
+		return nil
+	}
+	return fmt.Errorf("Unknown unmatched policy: %s", u.Policy)
+}
+
+// unmatchedServer returns the code-server of requests no resolver matches
+func (p *Proxy) unmatchedServer(routes *RouteTable) (Server, error) {
+	u := p.unmatched
+
+	switch u.Policy {
+	case "", UnmatchedFirst:
+		servers := routes.Servers()
+		if len(servers) == 0 {
+			return Server{}, ErrNoServer
+		}
+		return servers[0], nil
+	case UnmatchedServer:
+		s, ok := routes.Server(u.Server)
+		if !ok {
+			return Server{}, ErrNoServer
+		}
+		return s, nil
+	}
+	return Server{}, ErrNoRoute
+}
+
+var notFoundTemplate = template.Must(template.New("notfound").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Project not found</title></head>
+<body>
+<h1>Project not found</h1>
+<p>No project matches {{.Path}}.</p>
+{{if .Servers}}<ul>
+{{range .Servers}}<li><a href="{{.URL}}">{{.Alias}}</a> {{.Path}}</li>
+{{end}}</ul>{{else}}<p>No projects are registered.</p>{{end}}
+</body>
+</html>
+`))
+
+type notFoundServer struct {
+	Alias string
+	Path  string
+	URL   string
+}
+
+// unmatchedHandler responds to requests which can't be routed to a
+// code-server according to the unmatched policy
+func (p *Proxy) unmatchedHandler(w http.ResponseWriter, r *http.Request, err error) {
+	p.logger.WithFields(logrus.Fields{
+		"host":  r.Host,
+		"path":  requestPath(r),
+		"error": err,
+	}).Warn("Unmatched request")
+
+	if p.unmatched.Policy == UnmatchedRedirect && !isWebsocket(r) {
+		http.Redirect(w, r, p.unmatched.Redirect, http.StatusFound)
+		return
+	}
+
+	data := struct {
+		Path    string
+		Servers []notFoundServer
+	}{Path: requestPath(r)}
+
+	for _, s := range p.servers() {
+		u := p.aliasURL("", s.Alias)
+		if u == "" {
+			u = fmt.Sprintf("/%s/", s.Alias)
+		}
+		data.Servers = append(data.Servers, notFoundServer{Alias: s.Alias, Path: s.Path, URL: u})
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+	if terr := notFoundTemplate.Execute(w, data); terr != nil {
+		p.logger.Errorf("Failed to render not found page: %v", terr)
+	}
+}
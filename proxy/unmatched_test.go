@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestUnmatchedProxy(unmatched Unmatched, servers ...Server) (*Proxy, error) {
+	return NewProxy(
+		UseCode(Code{Servers: servers, Routing: Routing{Unmatched: unmatched}}),
+		UseLogger(logrus.New()),
+	)
+}
+
+func TestValidateUnmatched(t *testing.T) {
+	s := Server{Path: "/a/b/c", Alias: "project1", Port: 9000}
+
+	data := []struct {
+		unmatched Unmatched
+		valid     bool
+	}{
+		{Unmatched{}, true},
+		{Unmatched{Policy: UnmatchedFirst}, true},
+		{Unmatched{Policy: UnmatchedNotFound}, true},
+		{Unmatched{Policy: UnmatchedServer, Server: "project1"}, true},
+		{Unmatched{Policy: UnmatchedServer}, false},
+		{Unmatched{Policy: UnmatchedServer, Server: "nope"}, false},
+		{Unmatched{Policy: UnmatchedRedirect, Redirect: "/landing"}, true},
+		{Unmatched{Policy: UnmatchedRedirect, Redirect: "https://example.com/"}, true},
+		{Unmatched{Policy: UnmatchedRedirect}, false},
+		{Unmatched{Policy: UnmatchedRedirect, Redirect: "landing"}, false},
+		{Unmatched{Policy: UnmatchedRedirect, Redirect: "//example.com/"}, false},
+		{Unmatched{Policy: "nope"}, false},
+	}
+
+	for _, d := range data {
+		_, err := newTestUnmatchedProxy(d.unmatched, s)
+		if d.valid {
+			require.NoError(t, err, "%+v", d.unmatched)
+		} else {
+			require.Error(t, err, "%+v", d.unmatched)
+		}
+	}
+}
+
+func TestUnmatchedPolicy(t *testing.T) {
+	servers := []Server{
+		{Path: "/a/b/c", Alias: "project1", Port: 9000},
+		{Path: "/a/d/e", Alias: "project2", Port: 9001},
+	}
+
+	p, err := newTestUnmatchedProxy(Unmatched{Policy: UnmatchedServer, Server: "project2"}, servers...)
+	require.NoError(t, err)
+
+	res, err := p.resolve(httptest.NewRequest("GET", "/static/x.js", nil))
+	require.NoError(t, err)
+	require.Equal(t, RuleDefault, res.Rule)
+	require.Equal(t, "project2", res.Server.Alias)
+
+	// A removed default server is not routed to
+	require.NoError(t, p.removeServer("project2"))
+	_, err = p.resolve(httptest.NewRequest("GET", "/static/x.js", nil))
+	require.Equal(t, ErrNoServer, err)
+
+	p, err = newTestUnmatchedProxy(Unmatched{Policy: UnmatchedNotFound}, servers...)
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, httptest.NewRequest("GET", "/static/x.js", nil))
+	require.Equal(t, http.StatusNotFound, rr.Code)
+	require.Contains(t, rr.Body.String(), `<a href="/project1/">project1</a>`)
+	require.Contains(t, rr.Body.String(), `<a href="/project2/">project2</a>`)
+
+	p, err = newTestUnmatchedProxy(Unmatched{Policy: UnmatchedRedirect, Redirect: "/landing"}, servers...)
+	require.NoError(t, err)
+
+	rr = httptest.NewRecorder()
+	p.ServeHTTP(rr, httptest.NewRequest("GET", "/static/x.js", nil))
+	require.Equal(t, http.StatusFound, rr.Code)
+	require.Equal(t, "/landing", rr.Header().Get("Location"))
+}
+
+func TestUnmatchedEmptyRegistry(t *testing.T) {
+	for _, policy := range []string{"", UnmatchedFirst, UnmatchedNotFound} {
+		p, err := newTestUnmatchedProxy(Unmatched{Policy: policy})
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, httptest.NewRequest("GET", "/a/b/c", nil))
+		require.Equal(t, http.StatusNotFound, rr.Code, policy)
+		require.Contains(t, rr.Body.String(), "No projects are registered")
+
+		req := httptest.NewRequest("GET", "/a/b/c", nil)
+		req.Header.Set("Connection", "upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		rr = httptest.NewRecorder()
+		p.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusNotFound, rr.Code, policy)
+	}
+}
@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	minPort = 1
+	maxPort = 65535
+)
+
+// aliasPattern is the charset allowed in aliases. Aliases end up in URL
+// paths, so they are restricted to a single safe path segment.
+var aliasPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,62}$`)
+
+// reservedAliases are the route names of code-server-proxy itself, which
+// an alias would otherwise shadow.
+var reservedAliases = map[string]bool{
+	"api":      true,
+	"debug":    true,
+	"metrics":  true,
+	"register": true,
+	"remove":   true,
+	"sessions": true,
+	"status":   true,
+}
+
+// FieldError describes why a field of a registration is invalid
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned when a registration breaks validation rules
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	var b bytes.Buffer
+	b.WriteString("Invalid code-server")
+	for i, f := range e.Fields {
+		if i == 0 {
+			b.WriteString(": ")
+		} else {
+			b.WriteString("; ")
+		}
+		fmt.Fprintf(&b, "%s %s", f.Field, f.Message)
+	}
+	return b.String()
+}
+
+func (e *ValidationError) add(field, format string, args ...interface{}) {
+	e.Fields = append(e.Fields, FieldError{
+		Field:   field,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// orNil returns e if it holds any field errors, nil otherwise
+func (e *ValidationError) orNil() error {
+	if len(e.Fields) > 0 {
+		return e
+	}
+	return nil
+}
+
+// validateServer checks the fields of s against the validation rules of
+// registrations. It stats the folder of s, so it is called before taking
+// p.mu.
+func validateServer(s Server) *ValidationError {
+	verr := &ValidationError{}
+
+	validateFolder(verr, s.Path)
+	validateAlias(verr, s.Alias)
+
+	if s.Port < minPort || s.Port > maxPort {
+		verr.add("port", "must be between %d and %d", minPort, maxPort)
+	}
+
+	if s.Scheme != "" && s.Scheme != SchemeHTTP && s.Scheme != SchemeHTTPS {
+		verr.add("scheme", "must be %s or %s", SchemeHTTP, SchemeHTTPS)
+	}
+
+	for i, rule := range s.Rewrite {
+		validateRewrite(verr, i, rule)
+	}
+	return verr
+}
+
+// validateOverlaps adds to verr where s overlaps with registered servers.
+// The server registered under self is skipped so that it can be updated
+// in place. The caller must hold p.mu.
+func (p *Proxy) validateOverlaps(verr *ValidationError, s Server, self string) {
+	// Overlapping paths are routed to the same code-server by longest
+	// prefix, so they can't be told apart.
+	for _, server := range p.code.Servers {
+		if self != "" && server.Alias == self {
+			continue
+		}
+
+		if s.Path != "" && pathOverlaps(s.Path, server.Path) {
+			verr.add("path", "overlaps with the path of %s", server.Alias)
+		}
+
+		if s.Path != "" && pathOverlaps(s.Path, fmt.Sprintf("/%s", server.Alias)) {
+			verr.add("path", "overlaps with the alias of %s", server.Alias)
+		}
+
+		if s.Alias != "" && pathOverlaps(fmt.Sprintf("/%s", s.Alias), server.Path) {
+			verr.add("alias", "overlaps with the path of %s", server.Alias)
+		}
+	}
+}
+
+// validateFolder checks that folder is an existing project directory
+func validateFolder(verr *ValidationError, folder string) {
+	switch {
+	case folder == "":
+		verr.add("path", "is required")
+		return
+	case !path.IsAbs(folder):
+		verr.add("path", "must be an absolute path")
+		return
+	case path.Clean(folder) != folder:
+		verr.add("path", "must be a clean path")
+		return
+	case folder == "/":
+		verr.add("path", "must not be the root directory")
+		return
+	}
+
+	info, err := os.Stat(folder)
+	switch {
+	case os.IsNotExist(err):
+		verr.add("path", "does not exist")
+	case err != nil:
+		verr.add("path", "can not be accessed: %v", err)
+	case !info.IsDir():
+		verr.add("path", "is not a directory")
+	}
+}
+
+// validateAlias checks that alias is a safe, unreserved path segment
+func validateAlias(verr *ValidationError, alias string) {
+	switch {
+	case alias == "":
+		verr.add("alias", "is required")
+	case !aliasPattern.MatchString(alias):
+		verr.add("alias", "must start with a letter or digit and contain only letters, digits, '-' or '_'")
+	case reservedAliases[strings.ToLower(alias)]:
+		verr.add("alias", "%s is a reserved route name", alias)
+	}
+}
+
+// pathOverlaps reports if a and b are the same path or one is an ancestor
+// of the other.
+func pathOverlaps(a, b string) bool {
+	return a == b || isAncestor(a, b) || isAncestor(b, a)
+}
+
+// isAncestor reports if dir is a parent directory of p
+func isAncestor(dir, p string) bool {
+	return strings.HasPrefix(p, strings.TrimSuffix(dir, "/")+"/")
+}
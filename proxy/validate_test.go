@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateServer(t *testing.T) {
+	p, err := newTestProxy()
+	require.NoError(t, err)
+
+	dir, cleanup := newTestFolder(t)
+	defer cleanup()
+
+	folder := filepath.Join(dir, "coolproj")
+	file := filepath.Join(dir, "file")
+	require.NoError(t, ioutil.WriteFile(file, nil, 0644))
+
+	data := []struct {
+		server Server
+		fields []string
+	}{
+		{Server{Path: folder, Alias: "coolproj", Port: 1999}, nil},
+		{Server{Path: folder, Alias: "cool_proj-2", Port: 1999}, nil},
+		{Server{}, []string{"path", "alias", "port"}},
+		{Server{Path: "relative/dir", Alias: "coolproj", Port: 1999}, []string{"path"}},
+		{Server{Path: folder + "/../coolproj", Alias: "coolproj", Port: 1999}, []string{"path"}},
+		{Server{Path: "/", Alias: "coolproj", Port: 1999}, []string{"path"}},
+		{Server{Path: filepath.Join(dir, "missing"), Alias: "coolproj", Port: 1999}, []string{"path"}},
+		{Server{Path: file, Alias: "coolproj", Port: 1999}, []string{"path"}},
+		{Server{Path: folder, Alias: "status", Port: 1999}, []string{"alias"}},
+		{Server{Path: folder, Alias: "API", Port: 1999}, []string{"alias"}},
+		{Server{Path: folder, Alias: "../x", Port: 1999}, []string{"alias"}},
+		{Server{Path: folder, Alias: "-x", Port: 1999}, []string{"alias"}},
+		{Server{Path: folder, Alias: "coolproj", Port: 0}, []string{"port"}},
+		{Server{Path: folder, Alias: "coolproj", Port: 65536}, []string{"port"}},
+		{Server{Path: folder, Alias: "coolproj", Port: 9999, Scheme: "ftp"}, []string{"scheme"}},
+		{Server{Path: folder, Alias: "coolproj", Port: 9999, Scheme: SchemeHTTPS}, nil},
+		{Server{Path: "/a/b/c", Alias: "coolproj", Port: 1999}, []string{"path"}},   // Same path as project1
+		{Server{Path: "/a/b/c/d", Alias: "coolproj", Port: 1999}, []string{"path"}}, // Nested in project1
+		{Server{Path: "/a/b", Alias: "coolproj", Port: 1999}, []string{"path"}},     // Parent of project1
+		{Server{Path: "/project1", Alias: "coolproj", Port: 1999}, []string{"path"}},
+		{Server{Path: folder, Alias: "a", Port: 1999}, []string{"alias"}}, // "/a" is a parent of project1
+	}
+
+	validate := func(s Server, self string) error {
+		verr := validateServer(s)
+		p.validateOverlaps(verr, s, self)
+		return verr.orNil()
+	}
+
+	for _, d := range data {
+		err := validate(d.server, "")
+		if d.fields == nil {
+			require.NoError(t, err, "%+v", d.server)
+			continue
+		}
+
+		verr, ok := err.(*ValidationError)
+		require.True(t, ok, "%+v: expected validation error, got %v", d.server, err)
+
+		fields := map[string]bool{}
+		for _, f := range verr.Fields {
+			fields[f.Field] = true
+		}
+		for _, f := range d.fields {
+			require.True(t, fields[f], "%+v: field %s not reported in %v", d.server, f, verr)
+		}
+	}
+
+	// A server doesn't overlap with itself when it is updated in place
+	require.Error(t, validate(Server{Path: "/a/b/c", Alias: "project1", Port: 9000}, ""))
+	verr, ok := validate(Server{Path: "/a/b/c", Alias: "project1", Port: 9000}, "project1").(*ValidationError)
+	require.True(t, ok)
+	for _, f := range verr.Fields {
+		require.NotContains(t, f.Message, "overlaps")
+	}
+}
+
+func TestRegisterHandlerValidation(t *testing.T) {
+	p, err := newTestProxy()
+	require.NoError(t, err)
+
+	b, err := json.Marshal(RegisterRequest{
+		Folder: "/k/m/n",
+		Name:   "register",
+		Port:   "1999",
+	})
+	require.NoError(t, err)
+
+	req, err := http.NewRequest("POST", "/register", bytes.NewReader(b))
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusUnprocessableEntity, rr.Code, "incorrect response code")
+
+	aerr := decodeAPIError(t, rr)
+	require.Equal(t, ErrCodeValidationFailed, aerr.Code)
+	require.Equal(t, []FieldError{
+		{Field: "folder", Message: "does not exist"},
+		{Field: "name", Message: "register is a reserved route name"},
+	}, aerr.Fields)
+}
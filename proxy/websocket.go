@@ -1,107 +1,618 @@
 package proxy
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
-	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 	"github.com/sirupsen/logrus"
 )
 
-func (p *Proxy) websocketHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	filePath := vars["filePath"]
+// isWebsocket reports whether r is a websocket handshake. Connection and
+// Upgrade are lists of case-insensitive tokens, e.g. "keep-alive, Upgrade".
+func isWebsocket(r *http.Request) bool {
+	return websocket.IsWebSocketUpgrade(r)
+}
 
-	if path, ok := p.aliasToPath[filePath]; !ok {
-		filePath = fmt.Sprintf("/%s", filePath)
-	} else {
-		filePath = path
+// matchWebsocket matches websocket handshakes
+func matchWebsocket(r *http.Request, _ *mux.RouteMatch) bool {
+	return isWebsocket(r)
+}
+
+func (p *Proxy) websocketHandler(w http.ResponseWriter, r *http.Request) {
+	res, err := p.resolveRequest(r)
+	if err != nil {
+		p.unmatchedHandler(w, r, err)
+		return
 	}
 
-	filePath = strings.TrimSuffix(filePath, "/")
+	// Check the origin before the code-server is dialed
+	if !p.upgrader.CheckOrigin(r) {
+		p.rejectOrigin(r)
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return
+	}
 
-	port, _ := p.portMap.Get(filePath)
-	backendWsURL := url.URL{
-		Scheme: "ws",
-		Host:   fmt.Sprintf("localhost:%d", port),
+	if !p.sessions.acquire(res.Server.Alias, p.websocket.Limits.MaxSessionsPerServer) {
+		p.rejectSession(w, r, res)
+		return
 	}
+	defer p.sessions.release(res.Server.Alias)
+
+	if p.websocket.Mode == ModeRaw {
+		p.logger.WithFields(logrus.Fields{
+			"path":    requestPath(r),
+			"rule":    res.Rule,
+			"server":  res.Server.Alias,
+			"backend": res.BackendURL,
+		}).Info("Receive raw websocket connection request")
 
-	cookies := []string{}
-	for _, cookie := range r.Cookies() {
-		cookies = append(cookies, cookie.String())
+		p.rawWebsocketHandler(w, r, res)
+		return
 	}
 
-	header := http.Header{
-		"Cookie": cookies,
+	backendWsURL, err := websocketURL(res.BackendURL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
+	header := copyHeader(forwardHeader(r, res, false), handshakeHeaders)
+
 	p.logger.WithFields(logrus.Fields{
-		"filePath": filePath,
-		"backend":  backendWsURL.String(),
+		"path":    requestPath(r),
+		"rule":    res.Rule,
+		"server":  res.Server.Alias,
+		"backend": backendWsURL,
 	}).Info("Receive websocket connection request")
 
 	// websocket connection to backend
-	back, _, err := websocket.DefaultDialer.Dial(backendWsURL.String(), header)
+	dial := p.startSpan(r, "websocket dial", SpanClient)
+	dial.set("http.url", backendWsURL)
+	dial.set("server", res.Server.Alias)
+	dial.inject(header)
+
+	back, resp, err := p.dialer.Dial(backendWsURL, header)
+	if resp != nil {
+		dial.set("http.status_code", resp.StatusCode)
+	}
+	dial.fail(err)
+	dial.end()
 	if err != nil {
+		// Pass refused handshakes on, e.g. for authentication
+		if resp != nil {
+			respHeader := copyHeader(resp.Header, hopHeaders, handshakeHeaders)
+			rewriteResponseHeader(respHeader, r, res)
+			for h, vals := range respHeader {
+				w.Header()[h] = vals
+			}
+			w.WriteHeader(resp.StatusCode)
+			return
+		}
+
+		p.metrics.backendError(res.Server.Alias)
 		http.Error(w, err.Error(), http.StatusBadGateway)
 		return
 	}
 	defer back.Close()
+	p.setCompressionLevel(back)
+
+	// The backend's handshake response, including the negotiated
+	// subprotocol, is passed on to the frontend
+	respHeader := copyHeader(resp.Header, hopHeaders, handshakeHeaders)
+	rewriteResponseHeader(respHeader, r, res)
 
 	// websocket connection to frontend
-	front, err := p.upgrader.Upgrade(w, r, nil)
+	cw := countingResponseWriter{ResponseWriter: w, counter: &p.counters.frontend}
+	front, err := p.upgrader.Upgrade(cw, r, respHeader)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	defer front.Close()
+	p.setCompressionLevel(front)
+
+	s := p.newSession(front, back, Session{
+		Server:   res.Server.Alias,
+		Path:     requestPath(r),
+		ClientIP: p.trusted.clientIP(r),
+		User:     p.trusted.requestUser(r),
+		Start:    time.Now(),
+	})
+	if err := p.sessions.add(s); err != nil {
+		p.logger.Error(err)
+		writeClose(front, websocket.CloseInternalServerErr, "")
+		return
+	}
+	defer p.sessions.remove(s)
+
+	sp := p.startSpan(r, "websocket session", SpanInternal)
+	p.splice(s)
+	p.endSession(r, s, sp)
+}
+
+// websocketURL returns the websocket URL of the backend URL of a request,
+// keeping its path and query
+func websocketURL(backendURL string) (string, error) {
+	u, err := url.Parse(backendURL)
+	if err != nil {
+		return "", err
+	}
+
+	switch u.Scheme {
+	case SchemeHTTP:
+		u.Scheme = "ws"
+	case SchemeHTTPS:
+		u.Scheme = "wss"
+	default:
+		return "", fmt.Errorf("Unsupported backend scheme: %s", u.Scheme)
+	}
+	return u.String(), nil
+}
+
+// Defaults of websocket sessions
+const (
+	DefaultPingInterval = 30 * time.Second
+	DefaultWriteTimeout = 10 * time.Second
+)
+
+// closeTimeout is how long the other side of a tunnel is given to answer a
+// close frame before its connection is closed
+const closeTimeout = 5 * time.Second
+
+// Sides of a websocket session
+const (
+	sideFrontend = "frontend"
+	sideBackend  = "backend"
+)
+
+// Websocket configures the keepalive and timeouts of websocket sessions
+type Websocket struct {
+	// PingInterval is how often both sides are pinged, DefaultPingInterval
+	// if zero and never if negative
+	PingInterval time.Duration `yaml:"pingInterval,omitempty"`
+	// PongTimeout is how long a pinged side may stay silent, twice the
+	// ping interval if zero
+	PongTimeout time.Duration `yaml:"pongTimeout,omitempty"`
+	// IdleTimeout ends sessions without messages in either direction,
+	// disabled if zero
+	IdleTimeout time.Duration `yaml:"idleTimeout,omitempty"`
+	// WriteTimeout is the deadline of writing a message to either side,
+	// DefaultWriteTimeout if zero
+	WriteTimeout time.Duration `yaml:"writeTimeout,omitempty"`
+	// Mode is frame or raw, frame if empty. Raw sessions are not pinged,
+	// timed out, limited or captured, and compression is negotiated between
+	// browser and code-server directly.
+	Mode string `yaml:"mode,omitempty"`
+	// Compression configures permessage-deflate of both sides
+	Compression Compression `yaml:"compression,omitempty"`
+	// Capture records the frames of sessions of some projects
+	Capture Capture `yaml:"capture,omitempty"`
+	// Limits caps messages and sessions
+	Limits Limits `yaml:"limits,omitempty"`
+}
+
+// withDefaults fills in the defaults of ws
+func (ws Websocket) withDefaults() Websocket {
+	if ws.PingInterval == 0 {
+		ws.PingInterval = DefaultPingInterval
+	}
+	if ws.PongTimeout <= 0 {
+		ws.PongTimeout = 2 * ws.PingInterval
+	}
+	if ws.WriteTimeout <= 0 {
+		ws.WriteTimeout = DefaultWriteTimeout
+	}
+	return ws
+}
+
+// leg is one side of a websocket session
+type leg struct {
+	side    string
+	conn    *websocket.Conn
+	counter *legCounter
+}
+
+// session is a websocket session tunneled between frontend and backend
+type session struct {
+	id     string
+	info   Session
+	front  leg
+	back   leg
+	config Websocket
+	// lastActive is the time of the last message in UnixNano, accessed atomically
+	lastActive int64
+	toBackend  transferCounter
+	toFrontend transferCounter
+	capturer   *capturer
+	violations *violationCounters
+	metrics    *websocketMetrics
+	// endReason is why the session ended, set by splice
+	endReason string
+	// terminated receives the reason to end the session from outside
+	terminated chan string
+}
+
+// newSession creates a session between front and back described by info
+func (p *Proxy) newSession(front, back *websocket.Conn, info Session) *session {
+	return &session{
+		info:       info,
+		front:      leg{side: sideFrontend, conn: front, counter: &p.counters.frontend},
+		back:       leg{side: sideBackend, conn: back, counter: &p.counters.backend},
+		config:     p.websocket.withDefaults(),
+		capturer:   p.capturer,
+		violations: &p.counters.violations,
+		metrics:    p.metrics.websocketServer(info.Server),
+		terminated: make(chan string, 1),
+	}
+}
+
+// endSession records the end of the session s of r in the span sp and the
+// access log entry of r
+func (p *Proxy) endSession(r *http.Request, s *session, sp *span) {
+	info := s.describe()
+	sp.set("session", s.id)
+	sp.set("server", info.Server)
+	sp.set("session.end_reason", s.endReason)
+	sp.set("session.bytes_to_backend", info.ToBackend.Bytes)
+	sp.set("session.bytes_to_frontend", info.ToFrontend.Bytes)
+	sp.end()
+
+	accessRecorderOf(r).sessionEnded(s)
+}
+
+// describe returns the current state of the session
+func (s *session) describe() Session {
+	info := s.info
+	info.ID = s.id
+	info.ToBackend = s.toBackend.transfer()
+	info.ToFrontend = s.toFrontend.transfer()
+	return info
+}
+
+// terminate ends the session for reason, unless it is ending already
+func (s *session) terminate(reason string) {
+	select {
+	case s.terminated <- reason:
+	default:
+	}
+}
+
+// pumpResult is how a pump of a tunnel ended
+type pumpResult struct {
+	src leg
+	dst leg
+	// failed is the side the error occurred on
+	failed leg
+	err    error
+}
+
+// reason describes why the session ended
+func (r pumpResult) reason() string {
+	if le, ok := r.err.(*limitError); ok {
+		return fmt.Sprintf("%s violated a limit: %s", r.failed.side, le.reason)
+	}
+
+	if ce, ok := r.err.(*websocket.CloseError); ok {
+		if !isForwardableClose(ce.Code) {
+			return fmt.Sprintf("%s dropped the connection", r.failed.side)
+		}
+		return fmt.Sprintf("%s closed the session (%d)", r.failed.side, ce.Code)
+	}
+
+	if ne, ok := r.err.(net.Error); ok && ne.Timeout() {
+		if r.failed.side == r.src.side {
+			return fmt.Sprintf("%s stopped responding", r.failed.side)
+		}
+		return fmt.Sprintf("%s is too slow to receive", r.failed.side)
+	}
+	return fmt.Sprintf("%s failed", r.failed.side)
+}
+
+// splice pumps messages between front and back until either side ends the
+// session, goes silent or the session idles. Close frames are passed on
+// with their codes and reasons, and both pumps have stopped when splice
+// returns.
+func (p *Proxy) splice(s *session) {
+	front, back := s.front.conn, s.back.conn
+	s.touch()
+	s.keepReading(s.front)
+	s.keepReading(s.back)
 
-	f2b := make(chan error)
-	b2f := make(chan error)
+	results := make(chan pumpResult, 2)
 
 	// goroutine that transfers messages from backend to frontend
-	go p.transfer(front, back, b2f)
+	go s.transfer(s.front, s.back, results)
+
+	// goroutine that transfers messages from frontend to backend
+	go s.transfer(s.back, s.front, results)
 
-	// goroutine that transfers messages from frontend tp backend
-	go p.transfer(back, front, f2b)
+	// goroutine that pings both sides and watches for idle sessions
+	done := make(chan struct{})
+	timeouts := make(chan string, 1)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.keepalive(done, timeouts)
+	}()
+	defer wg.Wait()
+	defer close(done)
 
-	// If either direction fails, finish current websocket session
+	// If either direction ends, finish current websocket session
+	pending := 2
+	var reason string
+	var err error
 	select {
-	case <-f2b:
+	case first := <-results:
+		pending--
+		reason, err = first.reason(), first.err
+
+		if ce, ok := first.err.(*websocket.CloseError); ok && isForwardableClose(ce.Code) {
+			s.captureClose(first.dst, ce)
+			writeClose(first.dst.conn, ce.Code, ce.Text)
+		} else if le, ok := first.err.(*limitError); ok {
+			s.violations.add(le.reason)
+			writeClose(front, websocket.ClosePolicyViolation, le.reason)
+			writeClose(back, websocket.ClosePolicyViolation, le.reason)
+		} else {
+			writeClose(front, websocket.CloseGoingAway, "")
+			writeClose(back, websocket.CloseGoingAway, "")
+		}
+	case reason = <-timeouts:
+		writeClose(front, websocket.CloseGoingAway, reason)
+		writeClose(back, websocket.CloseGoingAway, reason)
+	case reason = <-s.terminated:
+		writeClose(front, websocket.CloseGoingAway, reason)
+		writeClose(back, websocket.CloseGoingAway, reason)
+	}
+
+	s.endReason = reason
+	p.logger.WithFields(logrus.Fields{
+		"session": s.id,
+		"server":  s.info.Server,
+		"reason":  reason,
+		"error":   err,
+	}).Info("Websocket session ended")
+
+	// Give the other side time to answer the close frame, the pumps stop
+	// when their connections are closed at the latest
+	timer := time.NewTimer(closeTimeout)
+	defer timer.Stop()
+
+	for pending > 0 {
+		select {
+		case <-results:
+			pending--
+		case <-timer.C:
+		}
+		front.Close()
+		back.Close()
+	}
+}
+
+// touch records activity of the session
+func (s *session) touch() {
+	atomic.StoreInt64(&s.lastActive, time.Now().UnixNano())
+}
+
+// idle returns how long the session had no messages
+func (s *session) idle() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&s.lastActive)))
+}
+
+// keepReading expects l to respond to pings within the pong timeout
+func (s *session) keepReading(l leg) {
+	if s.config.PingInterval < 0 {
 		return
-	case <-b2f:
+	}
+
+	s.extendRead(l)
+	l.conn.SetPongHandler(func(string) error {
+		s.extendRead(l)
+		return nil
+	})
+}
+
+// extendRead extends the read deadline of l after it responded
+func (s *session) extendRead(l leg) {
+	if s.config.PingInterval > 0 {
+		l.conn.SetReadDeadline(time.Now().Add(s.config.PongTimeout))
+	}
+}
+
+// keepalive pings both sides and reports idle sessions until done is closed
+func (s *session) keepalive(done <-chan struct{}, timeouts chan<- string) {
+	period := s.config.PingInterval
+	if s.config.IdleTimeout > 0 && (period < 0 || s.config.IdleTimeout < period) {
+		period = s.config.IdleTimeout
+	}
+	if period < 0 {
 		return
 	}
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	lastPing := time.Now()
+	for {
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+
+		if s.config.IdleTimeout > 0 && s.idle() >= s.config.IdleTimeout {
+			timeouts <- "session idled"
+			return
+		}
+
+		if s.config.PingInterval > 0 && time.Since(lastPing) >= s.config.PingInterval {
+			deadline := time.Now().Add(s.config.WriteTimeout)
+			s.front.conn.WriteControl(websocket.PingMessage, nil, deadline)
+			s.back.conn.WriteControl(websocket.PingMessage, nil, deadline)
+			lastPing = time.Now()
+		}
+	}
 }
 
-// transfer populates message from src to dst
-func (p *Proxy) transfer(dst, src *websocket.Conn, ch chan error) {
+// transfer populates messages from src to dst until either fails
+func (s *session) transfer(dst, src leg, results chan<- pumpResult) {
+	limiter := newDirectionLimiter(s.config.Limits)
 	for {
-		if terr := tunnel(dst, src); terr != nil {
-			p.logger.Info(terr.Error())
-			ch <- terr
+		if failed, terr := s.tunnel(dst, src, limiter); terr != nil {
+			results <- pumpResult{src: src, dst: dst, failed: failed, err: terr}
+			return
 		}
 	}
 }
 
-// tunnel reads from src websocket connection and sends to dst websocket connection.
-func tunnel(dst, src *websocket.Conn) error {
-	mt, r, err := src.NextReader()
+// isForwardableClose reports whether a close code received from one side
+// may be sent to the other side. Codes which report a connection failure
+// locally must not be sent.
+func isForwardableClose(code int) bool {
+	return code != websocket.CloseAbnormalClosure && code != websocket.CloseTLSHandshake
+}
+
+// writeClose sends a close frame to conn, errors of connections which are
+// gone already are ignored
+func writeClose(conn *websocket.Conn, code int, text string) {
+	msg := websocket.FormatCloseMessage(code, text)
+	_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+}
+
+// trackedReader records the errors of reading from the underlying reader,
+// and the bytes read if it has a buffer
+type trackedReader struct {
+	io.Reader
+	err error
+	buf *bytes.Buffer
+	// limit is the size of messages if positive, n the bytes read so far
+	limit int64
+	n     int64
+	// rate limits the bytes read, before they are written
+	rate *rateLimiter
+}
+
+func (r *trackedReader) Read(b []byte) (int, error) {
+	// Read a byte beyond the limit at most, to tell if it is exceeded
+	if r.limit > 0 && int64(len(b)) > r.limit-r.n+1 {
+		b = b[:r.limit-r.n+1]
+	}
+
+	n, err := r.Reader.Read(b)
+	r.n += int64(n)
+	if r.limit > 0 && r.n > r.limit {
+		n, err = n-1, &limitError{reason: violationMessageSize}
+	}
+	if n > 0 && !r.rate.take(float64(n)) {
+		n, err = 0, &limitError{reason: violationByteRate}
+	}
+
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	if r.buf != nil {
+		r.buf.Write(b[:n])
+	}
+	return n, err
+}
+
+// bytes returns the bytes read, or nil without buffer
+func (r *trackedReader) bytes() []byte {
+	if r.buf == nil {
+		return nil
+	}
+	return r.buf.Bytes()
+}
+
+// tunnel reads a message from src and sends it to dst within the write
+// timeout and the limits. It returns the side an error occurred on.
+func (s *session) tunnel(dst, src leg, limiter directionLimiter) (leg, error) {
+	mt, r, err := src.conn.NextReader()
 	if err != nil {
-		return err
+		return src, err
 	}
-	w, err := dst.NextWriter(mt)
+	s.touch()
+	s.extendRead(src)
+
+	if !limiter.messages.take(1) {
+		return src, &limitError{reason: violationMessageRate}
+	}
+
+	tr := &trackedReader{Reader: r, limit: s.config.Limits.MaxMessageSize, rate: limiter.bytes}
+	if s.capturesPayload() {
+		tr.buf = &bytes.Buffer{}
+	}
+
+	// Messages below the threshold are not worth compressing
+	var head []byte
+	more := true
+	if threshold := s.config.Compression.Threshold; threshold > 0 {
+		head = make([]byte, threshold)
+		n, rerr := io.ReadFull(tr, head)
+		if rerr != nil && rerr != io.EOF && rerr != io.ErrUnexpectedEOF {
+			return src, rerr
+		}
+		head = head[:n]
+		// Decompressing readers must not be read past the end
+		more = n == threshold
+		dst.conn.EnableWriteCompression(more)
+	}
+
+	dst.conn.SetWriteDeadline(time.Now().Add(s.config.WriteTimeout))
+	w, err := dst.conn.NextWriter(mt)
 	if err != nil {
-		return err
+		return dst, err
 	}
-	defer w.Close()
+	// Messages over the size or byte rate limit are not completed
+	defer func() {
+		if _, ok := tr.err.(*limitError); !ok {
+			w.Close()
+		}
+	}()
 
-	if _, cerr := io.Copy(w, r); cerr != nil {
-		return cerr
+	if _, werr := w.Write(head); werr != nil {
+		return dst, werr
 	}
-	return nil
+	var n int64
+	var cerr error
+	if more {
+		n, cerr = io.Copy(w, tr)
+	}
+	n += int64(len(head))
+	atomic.AddInt64(&src.counter.payload, n)
+	atomic.AddInt64(&dst.counter.payload, n)
+	if cerr != nil {
+		if tr.err != nil {
+			return src, cerr
+		}
+		return dst, cerr
+	}
+	s.transferred(dst).add(n)
+	s.metrics.add(dst.side, n)
+	s.capture(dst, mt, tr.bytes(), n)
+	return dst, nil
+}
+
+// transferred returns the counter of messages sent to dst
+func (s *session) transferred(dst leg) *transferCounter {
+	if dst.side == sideBackend {
+		return &s.toBackend
+	}
+	return &s.toFrontend
+}
+
+// setCompressionLevel sets the compression level of conn, which only
+// applies if compression was negotiated
+func (p *Proxy) setCompressionLevel(conn *websocket.Conn) {
+	conn.SetCompressionLevel(p.websocket.Compression.level())
 }
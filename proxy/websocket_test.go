@@ -0,0 +1,385 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestWebsocketProxy serves a proxy in front of the code-server stub
+// handler, registered as project1, and returns the websocket URL of the proxy
+func newTestWebsocketProxy(t testing.TB, handler http.HandlerFunc, options ...func(*Proxy) error) (*Proxy, string, func()) {
+	port, closeBackend := newTestBackend(t, handler)
+
+	options = append([]func(*Proxy) error{
+		UseCode(Code{Servers: []Server{{Path: "/a/b/c", Alias: "project1", Port: port}}}),
+		UseLogger(logrus.New()),
+	}, options...)
+
+	p, err := NewProxy(options...)
+	require.NoError(t, err)
+
+	front := httptest.NewServer(p)
+	return p, strings.Replace(front.URL, "http://", "ws://", 1), func() {
+		front.Close()
+		closeBackend()
+	}
+}
+
+func anyOrigin(*http.Request) bool { return true }
+
+// echo is a code-server stub echoing websocket messages
+func echo(upgrader websocket.Upgrader, header http.Header) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, header)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for {
+			mt, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(mt, msg); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func TestWebsocketHeaders(t *testing.T) {
+	received := make(chan http.Header, 1)
+	upgrader := websocket.Upgrader{Subprotocols: []string{"vscode"}, CheckOrigin: anyOrigin}
+	stub := echo(upgrader, http.Header{
+		"X-Backend":  []string{"code-server"},
+		"Set-Cookie": []string{"key=value; Path=/"},
+	})
+
+	_, wsURL, cleanup := newTestWebsocketProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header
+		stub(w, r)
+	}, UseUpgrader(websocket.Upgrader{CheckOrigin: anyOrigin}))
+	defer cleanup()
+
+	dialer := websocket.Dialer{Subprotocols: []string{"other", "vscode"}}
+	conn, resp, err := dialer.Dial(wsURL+"/project1/", http.Header{
+		"Origin":        []string{"https://ide.example.com"},
+		"Authorization": []string{"Bearer token"},
+		"User-Agent":    []string{"test"},
+		"Cookie":        []string{"a=b"},
+	})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Request headers reach the backend
+	header := <-received
+	require.Equal(t, "https://ide.example.com", header.Get("Origin"))
+	require.Equal(t, "Bearer token", header.Get("Authorization"))
+	require.Equal(t, "test", header.Get("User-Agent"))
+	require.Equal(t, "a=b", header.Get("Cookie"))
+	require.Equal(t, "127.0.0.1", header.Get("X-Forwarded-For"))
+	require.Equal(t, "/project1", header.Get("X-Forwarded-Prefix"))
+	require.Equal(t, "other, vscode", header.Get("Sec-Websocket-Protocol"))
+
+	// The handshake response reaches the frontend
+	require.Equal(t, "vscode", conn.Subprotocol())
+	require.Equal(t, "code-server", resp.Header.Get("X-Backend"))
+	require.Equal(t, "key=value; Path=/project1", resp.Header.Get("Set-Cookie"))
+
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("hello")))
+	_, msg, err := conn.ReadMessage()
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(msg))
+}
+
+func TestWebsocketRefused(t *testing.T) {
+	_, wsURL, cleanup := newTestWebsocketProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", "Basic")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	})
+	defer cleanup()
+
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL+"/project1/", nil)
+	require.Error(t, err)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	require.Equal(t, "Basic", resp.Header.Get("WWW-Authenticate"))
+}
+
+func TestMatchWebsocket(t *testing.T) {
+	for _, connection := range []string{"Upgrade", "upgrade", "keep-alive, Upgrade"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Connection", connection)
+		req.Header.Set("Upgrade", "websocket")
+		require.True(t, matchWebsocket(req, nil), connection)
+	}
+
+	require.False(t, matchWebsocket(httptest.NewRequest("GET", "/", nil), nil))
+}
+
+func TestWebsocketBackendURL(t *testing.T) {
+	received := make(chan string, 1)
+	stub := echo(websocket.Upgrader{}, nil)
+
+	_, wsURL, cleanup := newTestWebsocketProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		received <- r.URL.RequestURI()
+		stub(w, r)
+	})
+	defer cleanup()
+
+	// Path and query reach the backend, rewritten like HTTP requests
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"/project1/login/ws?token=a%2Fb&x=y", nil)
+	require.NoError(t, err)
+	conn.Close()
+
+	require.Equal(t, "/login/login/ws?token=a%2Fb&x=y", <-received)
+
+	for _, d := range []struct{ backend, expected string }{
+		{"http://localhost:9000/x?y=z", "ws://localhost:9000/x?y=z"},
+		{"https://localhost:9000/", "wss://localhost:9000/"},
+	} {
+		u, err := websocketURL(d.backend)
+		require.NoError(t, err)
+		require.Equal(t, d.expected, u)
+	}
+
+	_, err = websocketURL("ftp://localhost:9000/")
+	require.Error(t, err)
+}
+
+func TestWebsocketTLSBackend(t *testing.T) {
+	backend := httptest.NewTLSServer(echo(websocket.Upgrader{}, nil))
+	defer backend.Close()
+
+	u, err := url.Parse(backend.URL)
+	require.NoError(t, err)
+	port, err := strconv.Atoi(u.Port())
+	require.NoError(t, err)
+
+	// The certificate of the backend is issued for example.com
+	config := backend.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	config.ServerName = "example.com"
+
+	p, err := NewProxy(
+		UseCode(Code{Servers: []Server{{Path: "/a/b/c", Alias: "project1", Port: port, Scheme: SchemeHTTPS}}}),
+		UseLogger(logrus.New()),
+		UseBackendTLS(config),
+	)
+	require.NoError(t, err)
+
+	front := httptest.NewServer(p)
+	defer front.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial(strings.Replace(front.URL, "http://", "ws://", 1)+"/project1/", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("hello")))
+	_, msg, err := conn.ReadMessage()
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(msg))
+}
+
+func TestWebsocketClosePropagation(t *testing.T) {
+	backendClosed := make(chan *websocket.CloseError, 1)
+	_, wsURL, cleanup := newTestWebsocketProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		mt, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		// The backend ends the session if asked to
+		if string(msg) == "close" {
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(4001, "backend"))
+		} else {
+			conn.WriteMessage(mt, msg)
+		}
+
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				ce, _ := err.(*websocket.CloseError)
+				backendClosed <- ce
+				return
+			}
+		}
+	})
+	defer cleanup()
+
+	// Close frames of the frontend reach the backend
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"/project1/", nil)
+	require.NoError(t, err)
+
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("hello")))
+	_, _, err = conn.ReadMessage()
+	require.NoError(t, err)
+
+	require.NoError(t, conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(4000, "frontend")))
+	ce := <-backendClosed
+	require.NotNil(t, ce)
+	require.Equal(t, 4000, ce.Code)
+	require.Equal(t, "frontend", ce.Text)
+	conn.Close()
+
+	// Close frames of the backend reach the frontend
+	conn, _, err = websocket.DefaultDialer.Dial(wsURL+"/project1/", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("close")))
+	_, _, err = conn.ReadMessage()
+	require.IsType(t, &websocket.CloseError{}, err)
+	require.Equal(t, 4001, err.(*websocket.CloseError).Code)
+	require.Equal(t, "backend", err.(*websocket.CloseError).Text)
+}
+
+func TestWebsocketGoroutineLeak(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	_, wsURL, cleanup := newTestWebsocketProxy(t, echo(websocket.Upgrader{}, nil))
+
+	for i := 0; i < 50; i++ {
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL+"/project1/", nil)
+		require.NoError(t, err)
+
+		require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("hello")))
+		_, _, err = conn.ReadMessage()
+		require.NoError(t, err)
+
+		// Alternate clean closes and dropped connections
+		if i%2 == 0 {
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			conn.ReadMessage()
+		}
+		conn.Close()
+	}
+
+	cleanup()
+
+	// Goroutines of closed sessions may take a moment to return
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.True(t, runtime.NumGoroutine() <= before, "%d goroutines leaked", runtime.NumGoroutine()-before)
+}
+
+func TestWebsocketDefaults(t *testing.T) {
+	ws := Websocket{}.withDefaults()
+	require.Equal(t, DefaultPingInterval, ws.PingInterval)
+	require.Equal(t, 2*DefaultPingInterval, ws.PongTimeout)
+	require.Equal(t, DefaultWriteTimeout, ws.WriteTimeout)
+	require.Equal(t, time.Duration(0), ws.IdleTimeout)
+
+	ws = Websocket{PingInterval: -1}.withDefaults()
+	require.Equal(t, time.Duration(-1), ws.PingInterval)
+}
+
+// useWebsocket sets the websocket config of the test proxy
+func useWebsocket(ws Websocket) func(*Proxy) error {
+	return func(p *Proxy) error {
+		p.code.Websocket = ws
+		return nil
+	}
+}
+
+func TestWebsocketIdleTimeout(t *testing.T) {
+	_, wsURL, cleanup := newTestWebsocketProxy(t, echo(websocket.Upgrader{}, nil),
+		useWebsocket(Websocket{PingInterval: -1, IdleTimeout: 100 * time.Millisecond}))
+	defer cleanup()
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"/project1/", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, _, err = conn.ReadMessage()
+	require.IsType(t, &websocket.CloseError{}, err)
+	require.Equal(t, websocket.CloseGoingAway, err.(*websocket.CloseError).Code)
+	require.Equal(t, "session idled", err.(*websocket.CloseError).Text)
+}
+
+func TestWebsocketPongTimeout(t *testing.T) {
+	backendErr := make(chan error, 1)
+	_, wsURL, cleanup := newTestWebsocketProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// A dead backend doesn't answer pings
+		conn.SetPingHandler(func(string) error { return nil })
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				backendErr <- err
+				return
+			}
+		}
+	}, useWebsocket(Websocket{PingInterval: 50 * time.Millisecond, PongTimeout: 150 * time.Millisecond}))
+	defer cleanup()
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"/project1/", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// The frontend answers pings while reading, the backend is given up
+	_, _, err = conn.ReadMessage()
+	require.IsType(t, &websocket.CloseError{}, err)
+	require.Equal(t, websocket.CloseGoingAway, err.(*websocket.CloseError).Code)
+	require.Error(t, <-backendErr)
+}
+
+func TestWebsocketWriteTimeout(t *testing.T) {
+	backendErr := make(chan error, 1)
+	_, wsURL, cleanup := newTestWebsocketProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		go func() {
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		// Flood a frontend which doesn't read
+		msg := make([]byte, 64*1024)
+		for {
+			if err := conn.WriteMessage(websocket.BinaryMessage, msg); err != nil {
+				backendErr <- err
+				return
+			}
+		}
+	}, useWebsocket(Websocket{PingInterval: -1, WriteTimeout: 100 * time.Millisecond}))
+	defer cleanup()
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"/project1/", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	select {
+	case err := <-backendErr:
+		require.Error(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("session was not ended")
+	}
+}